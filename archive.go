@@ -18,12 +18,17 @@
 package archive
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
+	"slices"
 
 	"github.com/kumose-go/archive/config"
 	"github.com/kumose-go/archive/gzip"
+	"github.com/kumose-go/archive/internal/osutil"
 	"github.com/kumose-go/archive/tar"
 	"github.com/kumose-go/archive/targz"
 	"github.com/kumose-go/archive/tarxz"
@@ -32,50 +37,227 @@ import (
 )
 
 // Archive represents a compression archive files from disk can be written to.
+// Its methods may be called from multiple goroutines.
 type Archive interface {
 	Close() error
 	Add(f config.File) error
 }
 
+// ReaderAdder is implemented by archives whose files can be added from
+// memory rather than from disk.
+type ReaderAdder interface {
+	// AddReader adds a regular file named f.Destination, with f.Info as its
+	// metadata, whose size bytes of content are read from r. It is an error
+	// for r to hold fewer or more than size bytes; a negative size reads r
+	// to the end.
+	AddReader(f config.File, size int64, r io.Reader) error
+	// AddBytes adds a regular file named f.Destination with the given content.
+	AddBytes(f config.File, content []byte) error
+}
+
+// FSAdder is implemented by archives whose files can be read from an fs.FS.
+type FSAdder interface {
+	// AddFS adds the file f.Source read from fsys.
+	AddFS(fsys fs.FS, f config.File) error
+}
+
+// Checksummer is implemented by archives that hash the files added to them.
+type Checksummer interface {
+	// Checksums returns the digests of the regular files added, sorted by
+	// name, when CreateOptions.Checksums is set.
+	Checksums() []config.Checksum
+}
+
+// AddReader adds a regular file whose size bytes of content are read from r
+// to a, which must implement ReaderAdder.
+func AddReader(a Archive, f config.File, size int64, r io.Reader) error {
+	ra, ok := a.(ReaderAdder)
+	if !ok {
+		return fmt.Errorf("%T: adding from a reader: %w", a, errors.ErrUnsupported)
+	}
+	return ra.AddReader(f, size, r)
+}
+
+// AddBytes adds a regular file with the given content to a, which must
+// implement ReaderAdder.
+func AddBytes(a Archive, f config.File, content []byte) error {
+	ra, ok := a.(ReaderAdder)
+	if !ok {
+		return fmt.Errorf("%T: adding from memory: %w", a, errors.ErrUnsupported)
+	}
+	return ra.AddBytes(f, content)
+}
+
+// AddFS adds the file f.Source read from fsys to a, which must implement
+// FSAdder.
+func AddFS(a Archive, fsys fs.FS, f config.File) error {
+	fa, ok := a.(FSAdder)
+	if !ok {
+		return fmt.Errorf("%T: adding from a file system: %w", a, errors.ErrUnsupported)
+	}
+	return fa.AddFS(fsys, f)
+}
+
+// Checksums returns the digests of the regular files added to a, or nil
+// when a does not implement Checksummer.
+func Checksums(a Archive) []config.Checksum {
+	c, ok := a.(Checksummer)
+	if !ok {
+		return nil
+	}
+	return c.Checksums()
+}
+
 // New archive.
 func New(w io.Writer, format string) (Archive, error) {
+	return NewWithOptions(w, format, config.CreateOptions{})
+}
+
+// NewWithOptions creates an archive written according to opts.
+// Options that do not apply to the format are ignored.
+func NewWithOptions(w io.Writer, format string, opts config.CreateOptions) (Archive, error) {
 	switch format {
 	case "tar.gz", "tgz":
-		return targz.New(w), nil
+		return targz.NewWithOptions(w, opts), nil
 	case "tar":
-		return tar.New(w), nil
+		return tar.NewWithOptions(w, opts), nil
 	case "gz":
-		return gzip.New(w), nil
+		return gzip.NewWithOptions(w, opts), nil
 	case "tar.xz", "txz":
-		return tarxz.New(w), nil
+		return tarxz.NewWithOptions(w, opts), nil
 	case "tar.zst", "tzst":
-		return tarzst.New(w), nil
+		return tarzst.NewWithOptions(w, opts), nil
 	case "zip":
-		return zip.New(w), nil
+		return zip.NewWithOptions(w, opts), nil
 	}
 	return nil, fmt.Errorf("invalid archive format: %s", format)
 }
 
+// AddTree adds f.Source and, when it is a directory, everything below it,
+// read from fsys. Entries are placed under f.Destination and take f.Info as
+// their metadata, except for its mode which only applies to files.
+// Symlinks are archived as such, unless f.FollowSymlinks is set, in which
+// case linked directories are walked too and symlink cycles are errors.
+func AddTree(a Archive, fsys fs.FS, f config.File) error {
+	w := treeWalker{a: a, fsys: fsys, f: f}
+	return w.walk(f.Source, f.Source, f.Destination, nil)
+}
+
+type treeWalker struct {
+	a    Archive
+	fsys fs.FS
+	f    config.File
+}
+
+// dirID identifies a walked directory, by inode when the file system exposes
+// it, or by the path it resolves to otherwise.
+type dirID struct {
+	file osutil.FileID
+	real string
+}
+
+// walk adds name as dst, along with everything below it. real is the path
+// name resolves to once symlinks are followed, and ancestors identify the
+// directories being walked.
+func (w treeWalker) walk(name, real, dst string, ancestors []dirID) error {
+	info, link, err := osutil.Lstat(w.fsys, name, w.f.FollowSymlinks, false)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if link != "" && info.Mode()&fs.ModeSymlink == 0 && !path.IsAbs(link) {
+		real = path.Join(path.Dir(real), link)
+	}
+
+	if info.IsDir() {
+		id := dirID{real: real}
+		if file, _, ok := osutil.Inode(info); ok {
+			id = dirID{file: file}
+		}
+		if slices.Contains(ancestors, id) {
+			return fmt.Errorf("%s: symlink cycle to %s", name, link)
+		}
+		ancestors = append(ancestors, id)
+	}
+
+	// the root of an archive has no entry.
+	if dst != "" && dst != "." {
+		entry := config.File{
+			Source:         name,
+			Destination:    dst,
+			Info:           w.f.Info,
+			FollowSymlinks: w.f.FollowSymlinks,
+		}
+		if info.IsDir() {
+			entry.Info.Mode = 0
+		}
+		if err := AddFS(w.a, w.fsys, entry); err != nil {
+			return err
+		}
+	}
+	if !info.IsDir() {
+		return nil
+	}
+
+	entries, err := fs.ReadDir(w.fsys, name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for _, e := range entries {
+		err := w.walk(
+			path.Join(name, e.Name()),
+			path.Join(real, e.Name()),
+			path.Join(dst, e.Name()),
+			ancestors,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Copy copies the source archive into a new one, which can be appended at.
 // Source needs to be in the specified format.
 func Copy(r *os.File, w io.Writer, format string) (Archive, error) {
+	return CopyWithOptions(r, w, format, config.CreateOptions{})
+}
+
+// CopyWithOptions copies the source archive into a new one written
+// according to opts, which can be appended at. Source needs to be in the
+// specified format, which Auto detects.
+func CopyWithOptions(r *os.File, w io.Writer, format string, opts config.CreateOptions) (Archive, error) {
+	if format == Auto {
+		var err error
+		if format, err = detectFile(r); err != nil {
+			return nil, err
+		}
+	}
 	switch format {
 	case "tar.gz", "tgz":
-		return targz.Copy(r, w)
+		return targz.CopyWithOptions(r, w, opts)
 	case "tar":
-		return tar.Copy(r, w)
+		return tar.CopyWithOptions(r, w, opts)
 	case "zip":
-		return zip.Copy(r, w)
+		return zip.CopyWithOptions(r, w, opts)
 	}
 	return nil, fmt.Errorf("invalid archive format: %s", format)
 }
 
-// Unarchive extracts the source archive to destination according to format.
+// Unarchive extracts the source archive to destination according to format,
+// or to its content and name with the Auto format, see DetectFile.
 // opts controls strip top dir and overwrite behavior.
 func Unarchive(src, dest, format string, opts config.ExtractOptions) error {
+	if format == Auto {
+		var err error
+		if format, err = DetectFile(src); err != nil {
+			return err
+		}
+	}
 	switch format {
 	case "tar.gz", "tgz":
 		return targz.ExtractTargz(src, dest, opts)
+	case "gz":
+		return gzip.ExtractGzip(src, dest, opts)
 	case "tar":
 		return tar.ExtractTar(src, dest, opts)
 	case "tar.xz", "txz":
@@ -88,3 +270,41 @@ func Unarchive(src, dest, format string, opts config.ExtractOptions) error {
 		return fmt.Errorf("unsupported archive format: %s", format)
 	}
 }
+
+// ReadMetadata reads the archive level metadata of the source archive,
+// according to format, or Auto. The comments of zip entries are read by
+// zip.ReadMetadata.
+func ReadMetadata(src, format string) (config.Metadata, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return config.Metadata{}, err
+	}
+	defer f.Close()
+	if format == Auto {
+		if format, err = detectFile(f); err != nil {
+			return config.Metadata{}, err
+		}
+	}
+
+	switch format {
+	case "tar.gz", "tgz":
+		return targz.ReadMetadata(f)
+	case "tar":
+		return tar.ReadMetadata(f)
+	case "gz":
+		return gzip.ReadMetadata(f)
+	case "tar.xz", "txz":
+		return tarxz.ReadMetadata(f)
+	case "tar.zst", "tzst":
+		return tarzst.ReadMetadata(f)
+	case "zip":
+		info, err := f.Stat()
+		if err != nil {
+			return config.Metadata{}, err
+		}
+		meta, _, err := zip.ReadMetadata(f, info.Size())
+		return meta, err
+	default:
+		return config.Metadata{}, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
@@ -18,12 +18,24 @@
 package archive
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/internal/sized"
 	"github.com/kumose-go/archive/testlib"
 	"github.com/stretchr/testify/require"
 )
@@ -93,3 +105,436 @@ func TestArchive(t *testing.T) {
 		require.EqualError(t, err, "invalid archive format: 7z")
 	})
 }
+
+func TestArchiveAddReader(t *testing.T) {
+	for _, format := range []string{"tar.gz", "zip", "gz", "tar.xz", "tar", "tar.zst"} {
+		t.Run(format, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "archive"))
+			require.NoError(t, err)
+
+			archive, err := New(f, format)
+			require.NoError(t, err)
+			require.NoError(t, AddReader(archive, config.File{
+				Destination: "version",
+			}, 6, strings.NewReader("v1.0.0")))
+			require.NoError(t, archive.Close())
+			require.NoError(t, f.Close())
+
+			require.Equal(t, "v1.0.0", string(testlib.GetFileFromArchive(t, f.Name(), format, "version")))
+		})
+	}
+}
+
+func TestArchiveAddReaderSize(t *testing.T) {
+	for _, format := range []string{"tar.gz", "zip", "gz", "tar.xz", "tar", "tar.zst"} {
+		t.Run(format, func(t *testing.T) {
+			archive, err := New(io.Discard, format)
+			require.NoError(t, err)
+			defer archive.Close()
+			require.ErrorIs(t, AddReader(archive, config.File{
+				Destination: "long",
+			}, 4, strings.NewReader("v1.0.0")), sized.ErrTooLong)
+
+			archive, err = New(io.Discard, format)
+			require.NoError(t, err)
+			defer archive.Close()
+			require.ErrorIs(t, AddReader(archive, config.File{
+				Destination: "short",
+			}, 8, strings.NewReader("v1.0.0")), io.ErrUnexpectedEOF)
+		})
+	}
+}
+
+// addOnly is an Archive implementing none of the optional interfaces.
+type addOnly struct{}
+
+func (addOnly) Close() error            { return nil }
+func (addOnly) Add(_ config.File) error { return nil }
+
+func TestArchiveOptionalInterfaces(t *testing.T) {
+	var a Archive = addOnly{}
+	require.ErrorIs(t, AddReader(a, config.File{Destination: "a.txt"}, 1, strings.NewReader("a")), errors.ErrUnsupported)
+	require.ErrorIs(t, AddBytes(a, config.File{Destination: "a.txt"}, []byte("a")), errors.ErrUnsupported)
+	require.ErrorIs(t, AddFS(a, fstest.MapFS{}, config.File{Source: "a.txt"}), errors.ErrUnsupported)
+	require.Nil(t, Checksums(a))
+	require.ErrorIs(t, AddTree(a, fstest.MapFS{"a.txt": {}}, config.File{Source: "a.txt", Destination: "a.txt"}), errors.ErrUnsupported)
+}
+
+func TestArchiveHardlinks(t *testing.T) {
+	testlib.SkipIfWindows(t, "hardlinks are not detected on windows")
+	folder := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "a.txt"), []byte("content"), 0o644))
+	require.NoError(t, os.Link(filepath.Join(folder, "a.txt"), filepath.Join(folder, "b.txt")))
+
+	for _, format := range []string{"tar", "tar.gz", "tar.xz", "tar.zst"} {
+		t.Run(format, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "archive"))
+			require.NoError(t, err)
+
+			archive, err := NewWithOptions(f, format, config.CreateOptions{Checksums: []string{"sha256"}})
+			require.NoError(t, err)
+			for _, file := range []string{"a.txt", "b.txt"} {
+				require.NoError(t, archive.Add(config.File{
+					Source:      filepath.Join(folder, file),
+					Destination: file,
+				}))
+			}
+			require.NoError(t, archive.Close())
+			require.NoError(t, f.Close())
+
+			// hardlinks share the digests of the file they link to.
+			checksums := Checksums(archive)
+			require.Len(t, checksums, 2)
+			require.Equal(t, checksums[0].Digests, checksums[1].Digests)
+
+			require.Equal(t, "content", string(testlib.GetFileFromArchive(t, f.Name(), format, "a.txt")))
+			require.Empty(t, testlib.GetFileFromArchive(t, f.Name(), format, "b.txt"))
+
+			dest := t.TempDir()
+			require.NoError(t, Unarchive(f.Name(), dest, format, config.ExtractOptions{}))
+			bts, err := os.ReadFile(filepath.Join(dest, "b.txt"))
+			require.NoError(t, err)
+			require.Equal(t, "content", string(bts))
+		})
+	}
+}
+
+func TestAddTree(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	fsys := fstest.MapFS{
+		"root/bin/tool":      {Data: []byte("#!/bin/sh\n"), Mode: 0o755, ModTime: now},
+		"root/etc/app.yaml":  {Data: []byte("key: value\n"), Mode: 0o644, ModTime: now},
+		"root/etc/link.yaml": {Data: []byte("app.yaml"), Mode: fs.ModeSymlink | 0o777, ModTime: now},
+		"root/empty":         {Mode: fs.ModeDir | 0o700, ModTime: now},
+		"root/.hidden":       {Data: []byte("shh"), ModTime: now},
+		"other.txt":          {Data: []byte("other")},
+	}
+
+	for _, format := range []string{"tar", "tar.gz", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "archive"))
+			require.NoError(t, err)
+
+			archive, err := New(f, format)
+			require.NoError(t, err)
+			require.NoError(t, AddTree(archive, fsys, config.File{
+				Source:      "root",
+				Destination: "release",
+			}))
+			require.NoError(t, AddFS(archive, fsys, config.File{
+				Source:      "other.txt",
+				Destination: "other.txt",
+			}))
+			require.Error(t, AddFS(archive, fsys, config.File{
+				Source:      "nope.txt",
+				Destination: "nope.txt",
+			}))
+			require.NoError(t, archive.Close())
+			require.NoError(t, f.Close())
+
+			expected := []string{
+				"release",
+				"release/.hidden",
+				"release/bin",
+				"release/bin/tool",
+				"release/empty",
+				"release/etc",
+				"release/etc/app.yaml",
+				"release/etc/link.yaml",
+				"other.txt",
+			}
+			if format == "zip" {
+				expected = []string{
+					"release/",
+					"release/.hidden",
+					"release/bin/",
+					"release/bin/tool",
+					"release/empty/",
+					"release/etc/",
+					"release/etc/app.yaml",
+					"release/etc/link.yaml",
+					"other.txt",
+				}
+			}
+			require.Equal(t, expected, testlib.LsArchive(t, f.Name(), format))
+			require.Equal(t, "key: value\n", string(testlib.GetFileFromArchive(t, f.Name(), format, "release/etc/app.yaml")))
+			require.Equal(t, "other", string(testlib.GetFileFromArchive(t, f.Name(), format, "other.txt")))
+
+			dest := t.TempDir()
+			require.NoError(t, Unarchive(f.Name(), dest, format, config.ExtractOptions{}))
+			info, err := os.Stat(filepath.Join(dest, "release", "empty"))
+			require.NoError(t, err)
+			require.True(t, info.IsDir())
+			if testlib.IsWindows() {
+				return
+			}
+			info, err = os.Stat(filepath.Join(dest, "release", "bin", "tool"))
+			require.NoError(t, err)
+			require.Equal(t, fs.FileMode(0o755), info.Mode().Perm())
+			if format != "zip" {
+				link, err := os.Readlink(filepath.Join(dest, "release", "etc", "link.yaml"))
+				require.NoError(t, err)
+				require.Equal(t, "app.yaml", link)
+			}
+		})
+	}
+}
+
+func TestAddTreeFollowSymlinks(t *testing.T) {
+	fsys := fstest.MapFS{
+		"root/lib/libfoo.so": {Data: []byte("elf")},
+		"root/current":       {Data: []byte("lib"), Mode: fs.ModeSymlink | 0o777},
+		"loop/self":          {Data: []byte("."), Mode: fs.ModeSymlink | 0o777},
+		"loop/file.txt":      {Data: []byte("file")},
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "archive.tar"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive, err := New(f, "tar")
+	require.NoError(t, err)
+	require.NoError(t, AddTree(archive, fsys, config.File{
+		Source:         "root",
+		Destination:    "release",
+		FollowSymlinks: true,
+	}))
+	require.ErrorContains(t, AddTree(archive, fsys, config.File{
+		Source:         "loop",
+		Destination:    "loop",
+		FollowSymlinks: true,
+	}), "symlink cycle")
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	require.Equal(t, "elf", string(testlib.GetFileFromArchive(t, f.Name(), "tar", "release/current/libfoo.so")))
+	require.Equal(t, "elf", string(testlib.GetFileFromArchive(t, f.Name(), "tar", "release/lib/libfoo.so")))
+}
+
+func TestAddTreeSymlinkCycle(t *testing.T) {
+	testlib.SkipIfWindows(t, "symlinks need privileges on windows")
+	folder := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(folder, "a", "b"), 0o755))
+	require.NoError(t, os.Symlink("../../a", filepath.Join(folder, "a", "b", "up")))
+
+	archive, err := New(io.Discard, "tar")
+	require.NoError(t, err)
+	defer archive.Close()
+	require.NoError(t, AddTree(archive, os.DirFS(folder), config.File{
+		Source:      "a",
+		Destination: "a",
+	}))
+	require.ErrorContains(t, AddTree(archive, os.DirFS(folder), config.File{
+		Source:         "a",
+		Destination:    "followed",
+		FollowSymlinks: true,
+	}), "symlink cycle")
+}
+
+func TestArchiveTarFormat(t *testing.T) {
+	for _, format := range []string{"tar", "tar.gz", "tar.xz", "tar.zst"} {
+		t.Run(format, func(t *testing.T) {
+			archive, err := NewWithOptions(io.Discard, format, config.CreateOptions{TarFormat: "ustar"})
+			require.NoError(t, err)
+			defer archive.Close()
+			require.NoError(t, AddBytes(archive, config.File{Destination: "short.txt"}, nil))
+			require.ErrorContains(t, AddBytes(archive, config.File{
+				Destination: strings.Repeat("a", 101),
+			}, nil), "USTAR cannot encode Name")
+		})
+	}
+}
+
+func TestArchiveMetadata(t *testing.T) {
+	meta := config.Metadata{
+		Comment: "built by ci",
+		Extra:   []byte{'K', 'M', 3, 0, 'a', 'b', 'c'},
+		Records: map[string]string{"KUMOSE.version": "1.2.3", "KUMOSE.commit": "abc123"},
+	}
+	for format, expected := range map[string]config.Metadata{
+		"tar":     {Records: meta.Records},
+		"tar.gz":  meta,
+		"tar.xz":  {Records: meta.Records},
+		"tar.zst": {Records: meta.Records},
+		"gz":      {Comment: meta.Comment, Extra: meta.Extra},
+		"zip":     {Comment: meta.Comment},
+	} {
+		t.Run(format, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "archive"))
+			require.NoError(t, err)
+			defer f.Close()
+			archive, err := NewWithOptions(f, format, config.CreateOptions{Metadata: meta})
+			require.NoError(t, err)
+			require.NoError(t, AddBytes(archive, config.File{Destination: "a.txt"}, []byte("a")))
+			require.NoError(t, archive.Close())
+			require.NoError(t, f.Close())
+
+			actual, err := ReadMetadata(f.Name(), format)
+			require.NoError(t, err)
+			require.Equal(t, expected, actual)
+
+			if format == "gz" {
+				return
+			}
+			dest := t.TempDir()
+			require.NoError(t, Unarchive(f.Name(), dest, format, config.ExtractOptions{}))
+			entries, err := os.ReadDir(dest)
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			require.Equal(t, "a.txt", entries[0].Name())
+		})
+	}
+}
+
+func TestArchiveConcurrentAdd(t *testing.T) {
+	for _, format := range []string{"tar", "tar.gz", "tar.xz", "tar.zst", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "archive"))
+			require.NoError(t, err)
+			defer f.Close()
+			archive, err := New(f, format)
+			require.NoError(t, err)
+
+			var expected []string
+			var wg sync.WaitGroup
+			for i := range 10 {
+				name := fmt.Sprintf("dir/file%d.txt", i)
+				expected = append(expected, name)
+				wg.Go(func() {
+					require.NoError(t, AddBytes(archive, config.File{Destination: name}, []byte(name)))
+					require.ErrorIs(t, AddBytes(archive, config.File{Destination: name}, nil), fs.ErrExist)
+				})
+			}
+			wg.Wait()
+			require.NoError(t, archive.Close())
+			require.NoError(t, f.Close())
+			require.ElementsMatch(t, expected, testlib.LsArchive(t, f.Name(), format))
+		})
+	}
+}
+
+func TestArchiveChecksums(t *testing.T) {
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"bin/tool":  {Data: []byte("#!/bin/sh\necho hello\n"), Mode: 0o755, ModTime: mtime},
+		"bin/alias": {Data: []byte("tool"), Mode: fs.ModeSymlink | 0o777, ModTime: mtime},
+	}
+	sums := map[string]map[string]string{}
+	for name, content := range map[string]string{"bin/tool": "#!/bin/sh\necho hello\n", "README.md": "# readme\n"} {
+		sha := sha256.Sum256([]byte(content))
+		sha512 := sha512.Sum512([]byte(content))
+		sums[name] = map[string]string{"sha256": hex.EncodeToString(sha[:]), "sha512": hex.EncodeToString(sha512[:])}
+	}
+
+	for _, format := range []string{"tar", "tar.gz", "tar.xz", "tar.zst", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			archive, err := NewWithOptions(&buf, format, config.CreateOptions{
+				Checksums:        []string{"sha256", "sha512"},
+				ChecksumManifest: "CHECKSUMS",
+				ZipWorkers:       2,
+			})
+			require.NoError(t, err)
+			require.NoError(t, AddTree(archive, fsys, config.File{Source: "bin", Destination: "bin"}))
+			require.NoError(t, AddBytes(archive, config.File{Destination: "README.md"}, []byte("# readme\n")))
+			require.NoError(t, archive.Close())
+
+			require.Equal(t, []config.Checksum{
+				{Name: "README.md", Digests: sums["README.md"]},
+				{Name: "bin/tool", Digests: sums["bin/tool"]},
+			}, Checksums(archive))
+
+			fsys, err := NewFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()), format)
+			require.NoError(t, err)
+			manifest, err := fs.ReadFile(fsys, "CHECKSUMS")
+			require.NoError(t, err)
+			require.Equal(t, ""+
+				"SHA256 (README.md) = "+sums["README.md"]["sha256"]+"\n"+
+				"SHA512 (README.md) = "+sums["README.md"]["sha512"]+"\n"+
+				"SHA256 (bin/tool) = "+sums["bin/tool"]["sha256"]+"\n"+
+				"SHA512 (bin/tool) = "+sums["bin/tool"]["sha512"]+"\n",
+				string(manifest))
+		})
+	}
+}
+
+func TestArchiveChecksumsBlake2b(t *testing.T) {
+	var buf bytes.Buffer
+	archive, err := NewWithOptions(&buf, "zip", config.CreateOptions{
+		Checksums:        []string{"sha256", "blake2b"},
+		ChecksumManifest: "CHECKSUMS",
+	})
+	require.NoError(t, err)
+	require.NoError(t, AddBytes(archive, config.File{Destination: "abc.txt"}, []byte("abc")))
+	require.NoError(t, archive.Close())
+
+	fsys, err := NewFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "zip")
+	require.NoError(t, err)
+	manifest, err := fs.ReadFile(fsys, "CHECKSUMS")
+	require.NoError(t, err)
+	require.Equal(t, ""+
+		"SHA256 (abc.txt) = ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad\n"+
+		"BLAKE2b (abc.txt) = ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923\n",
+		string(manifest))
+}
+
+func TestArchiveChecksumManifestClash(t *testing.T) {
+	for _, format := range []string{"tar", "tar.gz", "tar.xz", "tar.zst", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			archive, err := NewWithOptions(&buf, format, config.CreateOptions{
+				Checksums:        []string{"sha256"},
+				ChecksumManifest: "a.txt",
+			})
+			require.NoError(t, err)
+			require.NoError(t, AddBytes(archive, config.File{Destination: "a.txt"}, []byte("a")))
+			require.ErrorIs(t, archive.Close(), fs.ErrExist)
+
+			// the archive is still terminated.
+			fsys, err := NewFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()), format)
+			require.NoError(t, err)
+			bts, err := fs.ReadFile(fsys, "a.txt")
+			require.NoError(t, err)
+			require.Equal(t, "a", string(bts))
+		})
+	}
+}
+
+func TestArchiveChecksumsSingleAlgorithm(t *testing.T) {
+	var sb strings.Builder
+	archive, err := NewWithOptions(&sb, "tar", config.CreateOptions{
+		Checksums:        []string{"sha256"},
+		ChecksumManifest: "SHA256SUMS",
+	})
+	require.NoError(t, err)
+	require.NoError(t, AddBytes(archive, config.File{Destination: "a.txt"}, []byte("a")))
+	require.NoError(t, archive.Close())
+
+	r, err := Open(strings.NewReader(sb.String()), "tar")
+	require.NoError(t, err)
+	defer r.Close()
+	for {
+		h, err := r.Next()
+		require.NoError(t, err)
+		if h.Name == "SHA256SUMS" {
+			manifest, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb  a.txt\n", string(manifest))
+			break
+		}
+	}
+
+	gz, err := NewWithOptions(io.Discard, "gz", config.CreateOptions{Checksums: []string{"sha256"}})
+	require.NoError(t, err)
+	require.NoError(t, AddBytes(gz, config.File{Destination: "a.txt"}, []byte("a")))
+	require.NoError(t, gz.Close())
+	require.Equal(t, []config.Checksum{{
+		Name:    "a.txt",
+		Digests: map[string]string{"sha256": "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"},
+	}}, Checksums(gz))
+
+	for _, format := range []string{"tar", "zip", "gz"} {
+		archive, err := NewWithOptions(io.Discard, format, config.CreateOptions{Checksums: []string{"md4"}})
+		require.NoError(t, err)
+		require.EqualError(t, AddBytes(archive, config.File{Destination: "a.txt"}, nil), "invalid checksum algorithm: md4")
+	}
+}
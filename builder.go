@@ -0,0 +1,176 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/internal/names"
+	"github.com/kumose-go/archive/internal/osutil"
+)
+
+// Builder collects the entries of an archive, possibly from multiple
+// goroutines, to validate them all up front and write them in a stable
+// order, each directory before its contents.
+type Builder struct {
+	mu      sync.Mutex
+	entries []builderEntry
+}
+
+// builderEntry is an entry read from fsys, or holding content when fsys is
+// nil.
+type builderEntry struct {
+	file    config.File
+	fsys    fs.FS
+	content []byte
+	name    string // normalized destination
+}
+
+// NewBuilder returns an empty Builder.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Add collects the file f.Source from disk.
+func (b *Builder) Add(f config.File) {
+	b.AddFS(osutil.FS{}, f)
+}
+
+// AddFS collects the file f.Source of fsys.
+func (b *Builder) AddFS(fsys fs.FS, f config.File) {
+	b.add(builderEntry{file: f, fsys: fsys})
+}
+
+// AddBytes collects a regular file with the given content.
+func (b *Builder) AddBytes(f config.File, content []byte) {
+	b.add(builderEntry{file: f, content: content})
+}
+
+func (b *Builder) add(e builderEntry) {
+	e.name = names.Normalize(e.file.Destination)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries = append(b.entries, e)
+}
+
+// Validate checks every collected entry: sources exist, destinations are
+// unique once normalized and FileInfo is valid. It returns all the problems
+// found, joined, or nil.
+func (b *Builder) Validate() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := b.sorted()
+	return err
+}
+
+// Build validates the collected entries and, when valid, writes them to a
+// in a stable order, sorted by destination with each directory before its
+// contents. Nothing is written when the entries are invalid.
+func (b *Builder) Build(a Archive) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entries, err := b.sorted()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.fsys == nil {
+			err = AddBytes(a, e.file, e.content)
+		} else {
+			err = AddFS(a, e.fsys, e.file)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sorted returns the collected entries in writing order, once validated.
+func (b *Builder) sorted() ([]builderEntry, error) {
+	entries := slices.Clone(b.entries)
+	// the stable sort keeps duplicates in the order they were collected.
+	slices.SortStableFunc(entries, func(x, y builderEntry) int {
+		return slices.Compare(strings.Split(x.name, "/"), strings.Split(y.name, "/"))
+	})
+
+	var errs []error
+	for i := range entries {
+		e := &entries[i]
+		if e.name == "" {
+			errs = append(errs, fmt.Errorf("%s: invalid destination %q", e.file.Source, e.file.Destination))
+			continue
+		}
+		if i > 0 && entries[i-1].name == e.name {
+			errs = append(errs, fmt.Errorf("%s: duplicate destination, also used by %s", e.name, describe(entries[i-1])))
+		}
+		if e.fsys != nil {
+			if _, _, err := osutil.Lstat(e.fsys, e.file.Source, e.file.FollowSymlinks, false); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", e.name, err))
+			}
+		}
+		for _, err := range validateInfo(&e.file) {
+			errs = append(errs, fmt.Errorf("%s: %w", e.name, err))
+		}
+	}
+	return entries, errors.Join(errs...)
+}
+
+// describe names the origin of an entry in error messages.
+func describe(e builderEntry) string {
+	if e.fsys == nil {
+		return "in-memory content"
+	}
+	return e.file.Source
+}
+
+// validateInfo checks the metadata set for f, parsing the modification time
+// when it was not already.
+func validateInfo(f *config.File) []error {
+	var errs []error
+	info := &f.Info
+	if info.Mode > 0o7777 {
+		errs = append(errs, fmt.Errorf("invalid mode %o, only permission bits can be set", info.Mode))
+	}
+	if info.UID != nil && *info.UID < 0 {
+		errs = append(errs, fmt.Errorf("invalid uid %d", *info.UID))
+	}
+	if info.GID != nil && *info.GID < 0 {
+		errs = append(errs, fmt.Errorf("invalid gid %d", *info.GID))
+	}
+	if info.MTime != "" && info.ParsedMTime.IsZero() {
+		mtime, err := time.Parse(time.RFC3339Nano, info.MTime)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid mtime %q: %w", info.MTime, err))
+		}
+		info.ParsedMTime = mtime
+	}
+	switch f.Method {
+	case "", "store", "deflate":
+	default:
+		errs = append(errs, fmt.Errorf("invalid compression method: %s", f.Method))
+	}
+	return errs
+}
@@ -0,0 +1,120 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/testlib"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder(t *testing.T) {
+	fsys := fstest.MapFS{
+		"src/bin":      {Mode: fs.ModeDir | 0o755},
+		"src/bin/tool": {Data: []byte("tool"), Mode: 0o755},
+	}
+
+	b := NewBuilder()
+	var wg sync.WaitGroup
+	for _, f := range []config.File{
+		{Source: "src/bin/tool", Destination: "release/bin/tool"},
+		{Source: "src/bin", Destination: "release/bin"},
+		{Source: "src/bin", Destination: "release"},
+	} {
+		wg.Go(func() { b.AddFS(fsys, f) })
+	}
+	wg.Go(func() {
+		b.AddBytes(config.File{
+			Destination: "./release/VERSION",
+			Info:        config.FileInfo{MTime: "2024-05-06T07:08:09Z"},
+		}, []byte("1.2.3"))
+	})
+	wg.Go(func() {
+		b.Add(config.File{Source: "testdata/regular.txt", Destination: "release-notes.txt"})
+	})
+	wg.Wait()
+	require.NoError(t, b.Validate())
+
+	for _, format := range []string{"tar", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "archive"))
+			require.NoError(t, err)
+			defer f.Close()
+			archive, err := New(f, format)
+			require.NoError(t, err)
+			require.NoError(t, b.Build(archive))
+			require.NoError(t, archive.Close())
+			require.NoError(t, f.Close())
+
+			expected := []string{"release", "release/VERSION", "release/bin", "release/bin/tool", "release-notes.txt"}
+			if format == "zip" {
+				expected = []string{"release/", "release/VERSION", "release/bin/", "release/bin/tool", "release-notes.txt"}
+			}
+			require.Equal(t, expected, testlib.LsArchive(t, f.Name(), format))
+
+			dest := t.TempDir()
+			require.NoError(t, Unarchive(f.Name(), dest, format, config.ExtractOptions{PreserveMTime: true}))
+			info, err := os.Stat(filepath.Join(dest, "release", "VERSION"))
+			require.NoError(t, err)
+			require.Equal(t, time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), info.ModTime().UTC())
+		})
+	}
+}
+
+func TestBuilderInvalid(t *testing.T) {
+	uid := -1
+	b := NewBuilder()
+	b.Add(config.File{Source: "testdata/nope.txt", Destination: "nope.txt"})
+	b.Add(config.File{Source: "testdata/foo.txt", Destination: "foo.txt"})
+	b.AddBytes(config.File{Destination: "./foo.txt"}, nil)
+	b.AddBytes(config.File{Destination: "info.txt", Method: "zstd", Info: config.FileInfo{
+		Mode:  fs.ModeDir | 0o755,
+		UID:   &uid,
+		MTime: "yesterday",
+	}}, nil)
+	b.AddBytes(config.File{Destination: "."}, nil)
+
+	err := b.Validate()
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	for _, msg := range []string{
+		"nope.txt: ",
+		"foo.txt: duplicate destination, also used by testdata/foo.txt",
+		"info.txt: invalid mode",
+		"info.txt: invalid uid -1",
+		`info.txt: invalid mtime "yesterday"`,
+		"info.txt: invalid compression method: zstd",
+		`invalid destination "."`,
+	} {
+		require.ErrorContains(t, err, msg)
+	}
+
+	var buf bytes.Buffer
+	archive, err := New(&buf, "tar")
+	require.NoError(t, err)
+	require.Error(t, b.Build(archive))
+	require.Zero(t, buf.Len())
+}
@@ -25,18 +25,29 @@ import (
 )
 
 // File is a file inside an archive.
+// Method overrides the compression method of the entry in zip archives,
+// either "store" or "deflate". FollowSymlinks archives the content symlinks
+// point to instead of the links themselves. Comment is stored as the entry
+// comment in zip archives.
 type File struct {
-	Source      string   `yaml:"src,omitempty" json:"src,omitempty"`
-	Destination string   `yaml:"dst,omitempty" json:"dst,omitempty"`
-	StripParent bool     `yaml:"strip_parent,omitempty" json:"strip_parent,omitempty"`
-	Info        FileInfo `yaml:"info,omitempty" json:"info,omitempty"`
-	Default     bool     `yaml:"-" json:"-"`
+	Source         string   `yaml:"src,omitempty" json:"src,omitempty"`
+	Destination    string   `yaml:"dst,omitempty" json:"dst,omitempty"`
+	StripParent    bool     `yaml:"strip_parent,omitempty" json:"strip_parent,omitempty"`
+	Info           FileInfo `yaml:"info,omitempty" json:"info,omitempty"`
+	Method         string   `yaml:"method,omitempty" json:"method,omitempty" jsonschema:"enum=store,enum=deflate"`
+	FollowSymlinks bool     `yaml:"follow_symlinks,omitempty" json:"follow_symlinks,omitempty"`
+	Comment        string   `yaml:"comment,omitempty" json:"comment,omitempty"`
+	Default        bool     `yaml:"-" json:"-"`
 }
 
 // FileInfo is the file info of a file.
+// UID and GID set the numeric owner ids; when unset, a named Owner or Group
+// is stored with id 0.
 type FileInfo struct {
 	Owner       string      `yaml:"owner,omitempty" json:"owner,omitempty"`
 	Group       string      `yaml:"group,omitempty" json:"group,omitempty"`
+	UID         *int        `yaml:"uid,omitempty" json:"uid,omitempty"`
+	GID         *int        `yaml:"gid,omitempty" json:"gid,omitempty"`
 	Mode        os.FileMode `yaml:"mode,omitempty" json:"mode,omitempty"`
 	MTime       string      `yaml:"mtime,omitempty" json:"mtime,omitempty"`
 	ParsedMTime time.Time   `yaml:"-" json:"-"`
@@ -0,0 +1,28 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package config
+
+// Metadata is the archive level metadata, such as build provenance.
+// Each format stores part of it: zip archives hold Comment, gzip headers
+// hold Comment and Extra, and tar archives hold Records in a PAX global
+// header.
+type Metadata struct {
+	Comment string            `yaml:"comment,omitempty" json:"comment,omitempty"`
+	Extra   []byte            `yaml:"extra,omitempty" json:"extra,omitempty"`
+	Records map[string]string `yaml:"records,omitempty" json:"records,omitempty"`
+}
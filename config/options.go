@@ -17,7 +17,77 @@
 
 package config
 
+// CreateOptions controls how archives are written.
+type CreateOptions struct {
+	DisableHardlinks bool // store every hardlinked file with its full content
+	DisableSparse    bool // store the holes of sparse files as zeros
+
+	RejectDanglingSymlinks bool // fail to add symlinks whose target does not exist
+
+	// TarFormat pins the header format of tar archives to "ustar", "pax" or
+	// "gnu". Entries that do not fit in it fail to be added. When empty, the
+	// format is chosen per entry. Sparse files are only stored as such in
+	// the PAX format.
+	TarFormat string
+
+	// Duplicates sets what happens when an entry is added under the name of
+	// another one, once both are normalized: "error" (the default), "keep"
+	// to skip it and keep the first one, or "replace" to write it as well,
+	// superseding the first one on extraction.
+	Duplicates string
+
+	Metadata Metadata // archive level metadata
+
+	// StoredExtensions lists the extensions of the files stored without
+	// compression in zip archives. When nil, a default list of already
+	// compressed formats is used.
+	StoredExtensions  []string
+	SampleCompression bool // store zip entries whose content does not shrink when compressed
+
+	// ZipWorkers sets the number of zip entries compressed in parallel,
+	// held in memory until appended in the order they were added, or as
+	// soon as they are compressed with ZipArrivalOrder. Errors met while
+	// compressing are then reported by a later call. Entries are compressed
+	// while added when zero.
+	ZipWorkers      int
+	ZipArrivalOrder bool
+
+	GzipBlockSize   int // bytes per block compressed in parallel by gzip, 1MiB by default
+	GzipConcurrency int // blocks compressed in parallel by gzip, GOMAXPROCS by default
+
+	// SeekableBlockSize makes tar.zst and tar.gz archives seekable: their
+	// content is compressed in independent blocks of that many bytes,
+	// zstd frames listed in a seek table or gzip members listed in a
+	// checkpoint index. Readers then decompress from the block holding an
+	// entry. Other readers still decompress them as usual.
+	SeekableBlockSize int
+
+	// Checksums lists the algorithms hashing the content of regular files
+	// while they are added: "sha256", "sha512" and "blake2b", the 512-bit
+	// digest of b2sum. The digests are then returned by the Checksums
+	// method of archives.
+	Checksums []string
+	// ChecksumManifest names an entry listing the digests, written last
+	// when the archive is closed. With a single algorithm, it follows the
+	// format of sha256sum, and the tagged one of sha256sum --tag otherwise.
+	// gz files, which hold a single file, have no manifest.
+	ChecksumManifest string
+}
+
+// ExtractOptions controls how archives are extracted.
 type ExtractOptions struct {
-	StripTopDir bool // remove top-level directory
-	Overwrite   bool // replace existing files
+	StripTopDir   bool // remove top-level directory
+	Overwrite     bool // replace existing files
+	PreserveOwner bool // restore recorded owner and group
+	PreserveMTime bool // restore recorded modification times
+}
+
+// SearchOptions controls how the contents of archives are searched.
+type SearchOptions struct {
+	// Name restricts the search to the entries whose name matches this
+	// path.Match pattern, or whose base name does when it has no slash.
+	// Nested archives are searched whatever their name.
+	Name string
+
+	Binary bool // search entries holding NUL bytes too, skipped as binary otherwise
 }
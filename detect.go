@@ -0,0 +1,220 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Auto is the format making read functions detect the format of archives,
+// see DetectFile.
+const Auto = "auto"
+
+// ErrUnknownFormat is returned when the format of an archive is not
+// recognized.
+var ErrUnknownFormat = errors.New("unknown archive format")
+
+// headSize is the amount of an archive read to detect its format.
+const headSize = 64 * 1024
+
+// Magic bytes of the recognized formats.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagics = [][]byte{[]byte("PK\x03\x04"), []byte("PK\x05\x06")}
+)
+
+// Detect returns the format of the archive starting with head, from its
+// magic bytes. Compressed streams are decompressed to tell tar archives
+// from single files, so head should hold the first 64KiB of the archive.
+func Detect(head []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		zr, err := gzip.NewReader(bytes.NewReader(head))
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+		}
+		if isTar(readHead(zr)) {
+			return "tar.gz", nil
+		}
+		return "gz", nil
+	case bytes.HasPrefix(head, xzMagic):
+		xzr, err := xz.NewReader(bytes.NewReader(head))
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+		}
+		if isTar(readHead(xzr)) {
+			return "tar.xz", nil
+		}
+		return "", fmt.Errorf("%w: xz stream without tar archive", ErrUnknownFormat)
+	case bytes.HasPrefix(head, zstdMagic):
+		dec, err := zstd.NewReader(bytes.NewReader(head))
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+		}
+		defer dec.Close()
+		if isTar(readHead(dec)) {
+			return "tar.zst", nil
+		}
+		return "", fmt.Errorf("%w: zstd stream without tar archive", ErrUnknownFormat)
+	case bytes.HasPrefix(head, zipMagics[0]), bytes.HasPrefix(head, zipMagics[1]):
+		return "zip", nil
+	case isTar(head):
+		return "tar", nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// readHead returns the first tar block read from r, or less when r is
+// shorter or truncated.
+func readHead(r io.Reader) []byte {
+	block := make([]byte, 512)
+	n, _ := io.ReadFull(r, block)
+	return block[:n]
+}
+
+// isTar reports whether head starts with a tar header: one with the ustar
+// magic, a valid checksum, or an empty archive.
+func isTar(head []byte) bool {
+	if len(head) >= 262 && string(head[257:262]) == "ustar" {
+		return true
+	}
+	if len(head) < 512 {
+		return false
+	}
+	block := head[:512]
+	if bytes.Count(block, []byte{0}) == len(block) {
+		return true
+	}
+	// the checksum sums the header bytes, its own field counted as spaces.
+	var sum int64
+	for i, b := range block {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		sum += int64(b)
+	}
+	field := strings.Trim(string(block[148:156]), " \x00")
+	var recorded int64
+	if _, err := fmt.Sscanf(field, "%o", &recorded); err != nil {
+		return false
+	}
+	return recorded == sum
+}
+
+// suffixes maps file name suffixes to formats, longest first.
+var suffixes = []struct{ suffix, format string }{
+	{".tar.gz", "tar.gz"},
+	{".tar.xz", "tar.xz"},
+	{".tar.zst", "tar.zst"},
+	{".tgz", "tar.gz"},
+	{".txz", "tar.xz"},
+	{".tzst", "tar.zst"},
+	{".tar", "tar"},
+	{".zip", "zip"},
+	{".gz", "gz"},
+}
+
+// DetectName returns the format of the archive named name, from its
+// suffix, or an empty string when not recognized.
+func DetectName(name string) string {
+	name = strings.ToLower(name)
+	for _, s := range suffixes {
+		if strings.HasSuffix(name, s.suffix) {
+			return s.format
+		}
+	}
+	return ""
+}
+
+// DetectFile returns the format of the src archive from its content, or
+// from its name when the content is not recognized.
+func DetectFile(src string) (string, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return detectFile(f)
+}
+
+// detectFile returns the format of the archive f, as DetectFile does.
+func detectFile(f *os.File) (string, error) {
+	head := make([]byte, headSize)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	format, err := Detect(head[:n])
+	if errors.Is(err, ErrUnknownFormat) {
+		if byName := DetectName(f.Name()); byName != "" {
+			return byName, nil
+		}
+		return "", fmt.Errorf("%s: %w", f.Name(), err)
+	}
+	return format, err
+}
+
+// detectReaderAt returns the format of the archive r of the given size.
+func detectReaderAt(r io.ReaderAt, size int64) (string, error) {
+	head := make([]byte, min(size, headSize))
+	if _, err := r.ReadAt(head, 0); err != nil && err != io.EOF {
+		return "", err
+	}
+	return Detect(head)
+}
+
+// detectReader returns the format of the archive read from r, along with
+// a reader yielding the whole archive: r itself, rewound, when it
+// implements io.Seeker.
+func detectReader(r io.Reader) (string, io.Reader, error) {
+	if s, ok := r.(io.ReadSeeker); ok {
+		start, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return "", nil, err
+		}
+		head := make([]byte, headSize)
+		n, err := io.ReadFull(s, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", nil, err
+		}
+		if _, err := s.Seek(start, io.SeekStart); err != nil {
+			return "", nil, err
+		}
+		format, err := Detect(head[:n])
+		return format, r, err
+	}
+	br := bufio.NewReaderSize(r, headSize)
+	head, err := br.Peek(headSize)
+	if err != nil && err != io.EOF {
+		return "", nil, err
+	}
+	format, err := Detect(head)
+	return format, br, err
+}
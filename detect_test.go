@@ -0,0 +1,155 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/kumose-go/archive/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetect(t *testing.T) {
+	for name, tc := range map[string]struct {
+		format string
+		opts   config.CreateOptions
+	}{
+		"tar":              {format: "tar"},
+		"tar ustar":        {format: "tar", opts: config.CreateOptions{TarFormat: "ustar"}},
+		"tar metadata":     {format: "tar", opts: config.CreateOptions{Metadata: config.Metadata{Records: map[string]string{"build": "42"}}}},
+		"tar.gz":           {format: "tar.gz"},
+		"tar.gz seekable":  {format: "tar.gz", opts: config.CreateOptions{SeekableBlockSize: 100}},
+		"gz":               {format: "gz"},
+		"tar.xz":           {format: "tar.xz"},
+		"tar.zst":          {format: "tar.zst"},
+		"tar.zst seekable": {format: "tar.zst", opts: config.CreateOptions{SeekableBlockSize: 100}},
+		"zip":              {format: "zip"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// the name is misleading on purpose.
+			src := filepath.Join(t.TempDir(), "download.zip")
+			f, err := os.Create(src)
+			require.NoError(t, err)
+			defer f.Close()
+			archive, err := NewWithOptions(f, tc.format, tc.opts)
+			require.NoError(t, err)
+			require.NoError(t, AddBytes(archive, config.File{Destination: "foo.txt"}, []byte("foo")))
+			require.NoError(t, archive.Close())
+			require.NoError(t, f.Close())
+
+			format, err := DetectFile(src)
+			require.NoError(t, err)
+			require.Equal(t, tc.format, format)
+
+			dest := t.TempDir()
+			require.NoError(t, Unarchive(src, dest, Auto, config.ExtractOptions{}))
+			content, err := os.ReadFile(filepath.Join(dest, "foo.txt"))
+			require.NoError(t, err)
+			require.Equal(t, "foo", string(content))
+
+			// readers without random access are peeked at.
+			bts, err := os.ReadFile(src)
+			require.NoError(t, err)
+			r, err := Open(io.MultiReader(bytes.NewReader(bts)), Auto)
+			require.NoError(t, err)
+			defer r.Close()
+			h, err := r.Next()
+			require.NoError(t, err)
+			require.Equal(t, "foo.txt", h.Name)
+			content, err = io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, "foo", string(content))
+
+			entries, err := List(src, Auto)
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+		})
+	}
+}
+
+func TestDetectEmptyTar(t *testing.T) {
+	var buf bytes.Buffer
+	archive, err := New(&buf, "tar.gz")
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+
+	format, err := Detect(buf.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, "tar.gz", format)
+
+	fsys, err := NewFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()), Auto)
+	require.NoError(t, err)
+	entries, err := fsys.ReadDir(".")
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestDetectUnknown(t *testing.T) {
+	_, err := Detect([]byte("just some text"))
+	require.ErrorIs(t, err, ErrUnknownFormat)
+
+	// the name is used when the content is not recognized.
+	folder := t.TempDir()
+	src := filepath.Join(folder, "text.tar.gz")
+	require.NoError(t, os.WriteFile(src, []byte("just some text"), 0o644))
+	format, err := DetectFile(src)
+	require.NoError(t, err)
+	require.Equal(t, "tar.gz", format)
+
+	src = filepath.Join(folder, "text.bin")
+	require.NoError(t, os.WriteFile(src, []byte("just some text"), 0o644))
+	_, err = DetectFile(src)
+	require.ErrorIs(t, err, ErrUnknownFormat)
+	require.ErrorIs(t, Unarchive(src, folder, Auto, config.ExtractOptions{}), ErrUnknownFormat)
+
+	// every function reading a file falls back to its name.
+	src = filepath.Join(folder, "empty.tar")
+	require.NoError(t, os.WriteFile(src, nil, 0o644))
+	entries, err := List(src, Auto)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	matches, err := Search(src, Auto, regexp.MustCompile("."), config.SearchOptions{})
+	require.NoError(t, err)
+	require.Empty(t, matches)
+	diff, err := Compare(src, Auto, src, Auto)
+	require.NoError(t, err)
+	require.Equal(t, Diff{}, diff)
+}
+
+func TestDetectName(t *testing.T) {
+	for name, format := range map[string]string{
+		"release.tar.gz":  "tar.gz",
+		"release.TGZ":     "tar.gz",
+		"release.tar.xz":  "tar.xz",
+		"release.txz":     "tar.xz",
+		"release.tar.zst": "tar.zst",
+		"release.tzst":    "tar.zst",
+		"release.tar":     "tar",
+		"release.zip":     "zip",
+		"notes.txt.gz":    "gz",
+		"release":         "",
+		"release.xz":      "",
+	} {
+		require.Equal(t, format, DetectName(name), name)
+	}
+}
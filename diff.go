@@ -0,0 +1,267 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kumose-go/archive/internal/names"
+)
+
+// Change is an attribute of an entry that differs between two archives.
+type Change string
+
+// Changes, in the order they are reported.
+const (
+	ChangeType    Change = "type"
+	ChangeContent Change = "content"
+	ChangeSize    Change = "size"
+	ChangeMode    Change = "mode"
+	ChangeOwner   Change = "owner"
+	ChangeMTime   Change = "mtime"
+	ChangeLink    Change = "link"
+)
+
+// Diff lists the differences between two archives, by entry name.
+type Diff struct {
+	Added   []Header
+	Removed []Header
+	Changed []EntryDiff
+}
+
+// EntryDiff describes an entry found in both archives with different
+// attributes. Digests are the hex encoded SHA-256 of the content of
+// regular files.
+type EntryDiff struct {
+	Name      string
+	Old, New  Header
+	OldDigest string
+	NewDigest string
+	Changes   []Change
+}
+
+// Compare compares the entries of the old and new archives, read according
+// to their formats, which may differ. Entries are matched by normalized
+// name, the last one counting when several share a name, and hardlinks are
+// compared as the files they link to. Only permission bits are compared
+// in modes, owners are compared by id, and modification times to the
+// second, as zip archives do not record more.
+func Compare(oldSrc, oldFormat, newSrc, newFormat string) (Diff, error) {
+	oldEntries, err := diffEntries(oldSrc, oldFormat)
+	if err != nil {
+		return Diff{}, err
+	}
+	newEntries, err := diffEntries(newSrc, newFormat)
+	if err != nil {
+		return Diff{}, err
+	}
+
+	var d Diff
+	for name, o := range oldEntries {
+		n, ok := newEntries[name]
+		if !ok {
+			d.Removed = append(d.Removed, o.header)
+			continue
+		}
+		if changes := compareEntries(o, n); len(changes) > 0 {
+			d.Changed = append(d.Changed, EntryDiff{
+				Name:      name,
+				Old:       o.header,
+				New:       n.header,
+				OldDigest: o.digest,
+				NewDigest: n.digest,
+				Changes:   changes,
+			})
+		}
+	}
+	for name, n := range newEntries {
+		if _, ok := oldEntries[name]; !ok {
+			d.Added = append(d.Added, n.header)
+		}
+	}
+	byName := func(a, b Header) int { return strings.Compare(a.Name, b.Name) }
+	slices.SortFunc(d.Added, byName)
+	slices.SortFunc(d.Removed, byName)
+	slices.SortFunc(d.Changed, func(a, b EntryDiff) int { return strings.Compare(a.Name, b.Name) })
+	return d, nil
+}
+
+type diffEntry struct {
+	header Header
+	digest string
+}
+
+// diffEntries reads the entries of the src archive, by normalized name,
+// hashing the content of regular files.
+func diffEntries(src, format string) (map[string]diffEntry, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if format == Auto {
+		if format, err = detectFile(f); err != nil {
+			return nil, err
+		}
+	}
+	r, err := Open(f, format)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	entries := map[string]diffEntry{}
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := names.Normalize(h.Name)
+		if name == "" {
+			continue
+		}
+		e := diffEntry{header: *h}
+		e.header.Name = name
+		switch h.Type {
+		case TypeFile:
+			hash := sha256.New()
+			size, err := io.Copy(hash, r)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", h.Name, err)
+			}
+			e.header.Size = size
+			e.digest = hex.EncodeToString(hash.Sum(nil))
+		case TypeHardlink:
+			if target, ok := entries[names.Normalize(h.Linkname)]; ok && target.header.Type == TypeFile {
+				e.header.Type = TypeFile
+				e.header.Size = target.header.Size
+				e.header.Linkname = ""
+				e.digest = target.digest
+			}
+		}
+		entries[name] = e
+	}
+}
+
+// compareEntries returns the attributes differing between o and n.
+func compareEntries(o, n diffEntry) []Change {
+	var changes []Change
+	if o.header.Type != n.header.Type {
+		changes = append(changes, ChangeType)
+	}
+	if o.digest != n.digest {
+		changes = append(changes, ChangeContent)
+	}
+	if o.header.Size != n.header.Size {
+		changes = append(changes, ChangeSize)
+	}
+	if permissions(o.header.Mode) != permissions(n.header.Mode) {
+		changes = append(changes, ChangeMode)
+	}
+	if o.header.UID != n.header.UID || o.header.GID != n.header.GID {
+		changes = append(changes, ChangeOwner)
+	}
+	if !o.header.ModTime.Truncate(time.Second).Equal(n.header.ModTime.Truncate(time.Second)) {
+		changes = append(changes, ChangeMTime)
+	}
+	if o.header.Linkname != n.header.Linkname {
+		changes = append(changes, ChangeLink)
+	}
+	return changes
+}
+
+// permissions returns the permission bits of mode, special ones included.
+func permissions(mode fs.FileMode) fs.FileMode {
+	return mode & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+}
+
+// Empty reports whether the archives hold the same entries.
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns a report of d, one line per entry sorted by name: "+" for
+// added entries, "-" for removed ones and "~" for changed ones, followed by
+// what changed.
+func (d Diff) String() string {
+	type line struct{ name, text string }
+	var lines []line
+	for _, h := range d.Added {
+		lines = append(lines, line{h.Name, "+ " + h.Name})
+	}
+	for _, h := range d.Removed {
+		lines = append(lines, line{h.Name, "- " + h.Name})
+	}
+	for _, e := range d.Changed {
+		lines = append(lines, line{e.Name, "~ " + e.String()})
+	}
+	slices.SortStableFunc(lines, func(a, b line) int { return strings.Compare(a.name, b.name) })
+
+	var sb strings.Builder
+	for _, l := range lines {
+		sb.WriteString(l.text)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// String describes the changes of the entry, such as
+// "bin/tool: size 20 -> 25, mode -rwxr-xr-x -> -rw-r--r--".
+func (e EntryDiff) String() string {
+	details := make([]string, 0, len(e.Changes))
+	for _, c := range e.Changes {
+		var o, n any
+		switch c {
+		case ChangeType:
+			o, n = e.Old.Type, e.New.Type
+		case ChangeContent:
+			o, n = shortDigest(e.OldDigest), shortDigest(e.NewDigest)
+		case ChangeSize:
+			o, n = e.Old.Size, e.New.Size
+		case ChangeMode:
+			o, n = permissions(e.Old.Mode), permissions(e.New.Mode)
+		case ChangeOwner:
+			o, n = fmt.Sprintf("%d:%d", e.Old.UID, e.Old.GID), fmt.Sprintf("%d:%d", e.New.UID, e.New.GID)
+		case ChangeMTime:
+			o, n = e.Old.ModTime.UTC().Format(time.RFC3339), e.New.ModTime.UTC().Format(time.RFC3339)
+		case ChangeLink:
+			o, n = fmt.Sprintf("%q", e.Old.Linkname), fmt.Sprintf("%q", e.New.Linkname)
+		}
+		details = append(details, fmt.Sprintf("%s %v -> %v", c, o, n))
+	}
+	return e.Name + ": " + strings.Join(details, ", ")
+}
+
+// shortDigest abbreviates a content digest for reports.
+func shortDigest(digest string) string {
+	if digest == "" {
+		return "none"
+	}
+	return "sha256:" + digest[:12]
+}
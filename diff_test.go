@@ -0,0 +1,97 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/kumose-go/archive/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	release := fstest.MapFS{
+		"bin":         {Mode: fs.ModeDir | 0o755, ModTime: mtime},
+		"bin/tool":    {Data: []byte("#!/bin/sh\necho 1\n"), Mode: 0o755, ModTime: mtime},
+		"bin/alias":   {Data: []byte("tool"), Mode: fs.ModeSymlink | 0o777, ModTime: mtime},
+		"bin/old.sh":  {Data: []byte("old"), Mode: 0o755, ModTime: mtime},
+		"README.md":   {Data: []byte("# release\n"), Mode: 0o644, ModTime: mtime},
+		"LICENSE.txt": {Data: []byte("license"), Mode: 0o644, ModTime: mtime},
+	}
+	folder := t.TempDir()
+	write := func(name, format string, fsys fs.FS) string {
+		t.Helper()
+		src := filepath.Join(folder, name)
+		f, err := os.Create(src)
+		require.NoError(t, err)
+		defer f.Close()
+		archive, err := New(f, format)
+		require.NoError(t, err)
+		require.NoError(t, AddTree(archive, fsys, config.File{Source: ".", Destination: "."}))
+		require.NoError(t, archive.Close())
+		return src
+	}
+
+	// the same release in different formats has no difference.
+	tgz := write("release.tar.gz", "tar.gz", release)
+	zip := write("release.zip", "zip", release)
+	d, err := Compare(tgz, "tar.gz", zip, "zip")
+	require.NoError(t, err)
+	require.True(t, d.Empty(), d.String())
+	require.Empty(t, d.String())
+
+	later := mtime.Add(time.Hour)
+	next := fstest.MapFS{
+		"bin":         release["bin"],
+		"bin/tool":    {Data: []byte("#!/bin/sh\necho 2\n"), Mode: 0o755, ModTime: mtime},
+		"bin/alias":   {Data: []byte("new.sh"), Mode: fs.ModeSymlink | 0o777, ModTime: mtime},
+		"bin/new.sh":  {Data: []byte("new"), Mode: 0o755, ModTime: mtime},
+		"README.md":   {Data: []byte("# release 2\n"), Mode: 0o600, ModTime: later},
+		"LICENSE.txt": release["LICENSE.txt"],
+	}
+	d, err = Compare(tgz, Auto, write("next.zip", "zip", next), Auto)
+	require.NoError(t, err)
+	require.False(t, d.Empty())
+	require.Len(t, d.Added, 1)
+	require.Equal(t, "bin/new.sh", d.Added[0].Name)
+	require.Len(t, d.Removed, 1)
+	require.Equal(t, "bin/old.sh", d.Removed[0].Name)
+	require.Len(t, d.Changed, 3)
+	require.Equal(t, "README.md", d.Changed[0].Name)
+	require.Equal(t, []Change{ChangeContent, ChangeSize, ChangeMode, ChangeMTime}, d.Changed[0].Changes)
+	require.Equal(t, "bin/alias", d.Changed[1].Name)
+	require.Equal(t, []Change{ChangeLink}, d.Changed[1].Changes)
+	require.Equal(t, "bin/tool", d.Changed[2].Name)
+	require.Equal(t, []Change{ChangeContent}, d.Changed[2].Changes)
+	require.NotEqual(t, d.Changed[2].OldDigest, d.Changed[2].NewDigest)
+
+	require.Equal(t, ""+
+		"~ README.md: content sha256:"+d.Changed[0].OldDigest[:12]+" -> sha256:"+d.Changed[0].NewDigest[:12]+
+		", size 10 -> 12, mode -rw-r--r-- -> -rw-------, mtime 2024-05-06T07:08:09Z -> 2024-05-06T08:08:09Z\n"+
+		"~ bin/alias: link \"tool\" -> \"new.sh\"\n"+
+		"+ bin/new.sh\n"+
+		"- bin/old.sh\n"+
+		"~ bin/tool: content sha256:"+d.Changed[2].OldDigest[:12]+" -> sha256:"+d.Changed[2].NewDigest[:12]+"\n",
+		d.String())
+}
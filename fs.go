@@ -0,0 +1,459 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kumose-go/archive/internal/names"
+	"github.com/kumose-go/archive/internal/tarutil"
+)
+
+// maxLinks is the number of symlinks followed when resolving a path.
+const maxLinks = 40
+
+// FS is a read-only file system over the entries of an archive, built from
+// the central directory of zip archives and from an index of tar archives.
+// Parent directories missing from the archive are synthesized, and later
+// entries supersede the ones of the same name. Entries whose name escapes
+// the archive root are left out.
+//
+// The content of compressed tar entries is read by decompressing the
+// archive up to the entry, every time it is opened.
+type FS struct {
+	entries map[string]*fsEntry
+}
+
+var (
+	_ fs.ReadDirFS   = (*FS)(nil)
+	_ fs.StatFS      = (*FS)(nil)
+	_ fs.ReadLinkFS  = (*FS)(nil)
+	_ fs.ReadDirFile = (*fsDir)(nil)
+)
+
+type fsEntry struct {
+	header   *Header
+	open     func() (io.ReadCloser, error) // nil for entries without content
+	children []string                      // names of the entries of a directory
+}
+
+// NewFS returns a file system over the archive r of the given size,
+// according to format: zip or any tar format, or Auto.
+func NewFS(r io.ReaderAt, size int64, format string) (*FS, error) {
+	if format == Auto {
+		var err error
+		if format, err = detectReaderAt(r, size); err != nil {
+			return nil, err
+		}
+	}
+	f := &FS{entries: map[string]*fsEntry{
+		".": {header: &Header{Name: ".", Type: TypeDir, Mode: fs.ModeDir | 0o755}},
+	}}
+	var err error
+	switch format {
+	case "zip":
+		err = f.indexZip(r, size)
+	case "tar.gz", "tgz", "tar", "tar.xz", "txz", "tar.zst", "tzst":
+		err = f.indexTar(r, size, format)
+	default:
+		err = fmt.Errorf("unsupported archive format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.link()
+	return f, nil
+}
+
+func (f *FS) indexZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		header := zipHeader(zf)
+		e := &fsEntry{header: header}
+		switch header.Type {
+		case TypeSymlink:
+			link, err := readAll(zf.Open)
+			if err != nil {
+				return fmt.Errorf("%s: %w", zf.Name, err)
+			}
+			header.Linkname = string(link)
+			header.Size = 0
+		case TypeFile:
+			e.open = func() (io.ReadCloser, error) { return zf.Open() }
+		}
+		f.add(e)
+	}
+	return nil
+}
+
+func (f *FS) indexTar(r io.ReaderAt, size int64, format string) error {
+	src := io.NewSectionReader(r, 0, size)
+	rc, err := decompress(src, format)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// the offset of each entry is the one of its first header in the tar
+	// stream, so archive/tar reads it back whatever its encoding.
+	counter := &countingReader{r: rc}
+	w := &tarWalker{tr: tar.NewReader(counter), pos: func() int64 { return counter.n }}
+	if s, ok := rc.(io.Seeker); ok {
+		// skipping the content by seeking is faster.
+		w.tr = tar.NewReader(rc)
+		w.pos = func() int64 {
+			n, _ := s.Seek(0, io.SeekCurrent)
+			return n
+		}
+	}
+	for {
+		h, start, err := w.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		e := &fsEntry{header: tarHeader(h)}
+		if e.header.Type == TypeFile {
+			e.open = func() (io.ReadCloser, error) {
+				return openTarEntry(r, size, format, start)
+			}
+		}
+		f.add(e)
+	}
+}
+
+// tarWalker reads the entries of a tar stream along with the offset of
+// their first header, pos returning the current offset in the stream.
+type tarWalker struct {
+	tr     *tar.Reader
+	pos    func() int64
+	offset int64 // of the next header
+}
+
+// next advances to the next entry, returning its header and offset. The
+// header is returned along with errors reading the entry past it.
+func (w *tarWalker) next() (*tar.Header, int64, error) {
+	start := w.offset
+	h, err := w.tr.Next()
+	if err != nil {
+		return nil, start, err
+	}
+	switch {
+	case tarutil.IsSparse(h):
+		// the content size is the one of the expanded file: read it to
+		// reach its end.
+		if _, err := io.Copy(io.Discard, w.tr); err != nil {
+			return h, start, err
+		}
+		w.offset = w.pos() + tarutil.Padding(w.pos())
+	case tarutil.HeaderOnly(h.Typeflag):
+		w.offset = w.pos()
+	default:
+		w.offset = w.pos() + h.Size + tarutil.Padding(h.Size)
+	}
+	return h, start, nil
+}
+
+// openTarEntry returns the content of the tar entry whose headers start at
+// offset in the tar stream.
+func openTarEntry(r io.ReaderAt, size int64, format string, offset int64) (io.ReadCloser, error) {
+	rc, err := decompress(io.NewSectionReader(r, 0, size), format)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := rc.(io.Seeker); ok {
+		_, err = s.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, rc, offset)
+	}
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	tr := tar.NewReader(rc)
+	if _, err := tr.Next(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{tr, rc}, nil
+}
+
+// add indexes e under its normalized name, along with its missing parents.
+func (f *FS) add(e *fsEntry) {
+	name := names.Normalize(e.header.Name)
+	if name == "" || !fs.ValidPath(name) {
+		return
+	}
+	e.header.Name = name
+	if prev, ok := f.entries[name]; ok {
+		e.children = prev.children
+	}
+	f.entries[name] = e
+	for name != "." {
+		dir := path.Dir(name)
+		parent, ok := f.entries[dir]
+		if !ok {
+			parent = &fsEntry{header: &Header{Name: dir, Type: TypeDir, Mode: fs.ModeDir | 0o755}}
+			f.entries[dir] = parent
+		}
+		if slices.Contains(parent.children, name) {
+			return
+		}
+		parent.children = append(parent.children, name)
+		name = dir
+	}
+}
+
+// link turns hardlinks into regular files sharing the content of their
+// target, and sorts the entries of directories.
+func (f *FS) link() {
+	for _, e := range f.entries {
+		slices.Sort(e.children)
+		if e.header.Type != TypeHardlink {
+			continue
+		}
+		e.header.Type = TypeFile
+		target, ok := f.entries[names.Normalize(e.header.Linkname)]
+		if ok && target.header.Type == TypeFile {
+			e.header.Size = target.header.Size
+			e.open = target.open
+		}
+	}
+}
+
+// resolve returns the entry of name, following the symlinks met along the
+// way, including the last element when follow is set.
+func (f *FS) resolve(op, name string, follow bool) (*fsEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	notExist := &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	resolved, rest, links := ".", name, 0
+	if rest == "." {
+		rest = ""
+	}
+	for rest != "" {
+		var elem string
+		elem, rest, _ = strings.Cut(rest, "/")
+		current := path.Join(resolved, elem)
+		e, ok := f.entries[current]
+		if !ok {
+			return nil, notExist
+		}
+		if e.header.Type != TypeSymlink || (rest == "" && !follow) {
+			resolved = current
+			continue
+		}
+		if links++; links > maxLinks {
+			return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many links")}
+		}
+		target := path.Join(resolved, e.header.Linkname)
+		if path.IsAbs(e.header.Linkname) || !fs.ValidPath(target) {
+			return nil, notExist
+		}
+		if target != "." {
+			rest = strings.TrimSuffix(target+"/"+rest, "/")
+		}
+		resolved = "."
+	}
+	return f.entries[resolved], nil
+}
+
+// Open opens the named file, following symlinks.
+func (f *FS) Open(name string) (fs.File, error) {
+	e, err := f.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info := fileInfo{e.header}
+	if e.header.Type == TypeDir {
+		return &fsDir{fs: f, info: info, children: e.children}, nil
+	}
+	if e.open == nil {
+		return &fsFile{info: info, rc: io.NopCloser(strings.NewReader(""))}, nil
+	}
+	rc, err := e.open()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &fsFile{info: info, rc: rc}, nil
+}
+
+// Stat returns the file info of the named file, following symlinks.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	e, err := f.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{e.header}, nil
+}
+
+// Lstat returns the file info of the named file, without following a
+// symlink in last position.
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	e, err := f.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{e.header}, nil
+}
+
+// ReadLink returns the target of the named symlink.
+func (f *FS) ReadLink(name string) (string, error) {
+	e, err := f.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if e.header.Type != TypeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return e.header.Linkname, nil
+}
+
+// ReadDir returns the entries of the named directory, sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := f.resolve("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if e.header.Type != TypeDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return f.dirEntries(e.children), nil
+}
+
+func (f *FS) dirEntries(children []string) []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, child := range children {
+		entries = append(entries, fs.FileInfoToDirEntry(fileInfo{f.entries[child].header}))
+	}
+	return entries
+}
+
+// fileInfo describes an archive entry. Sys returns its *Header.
+type fileInfo struct {
+	h *Header
+}
+
+func (i fileInfo) Name() string       { return path.Base(i.h.Name) }
+func (i fileInfo) Size() int64        { return i.h.Size }
+func (i fileInfo) Mode() fs.FileMode  { return i.h.Mode&^fs.ModeType | typeMode(i.h.Type) }
+func (i fileInfo) ModTime() time.Time { return i.h.ModTime }
+func (i fileInfo) IsDir() bool        { return i.h.Type == TypeDir }
+func (i fileInfo) Sys() any           { return i.h }
+
+// typeMode returns the type bits of the file mode of entries of type t.
+func typeMode(t EntryType) fs.FileMode {
+	switch t {
+	case TypeDir:
+		return fs.ModeDir
+	case TypeSymlink:
+		return fs.ModeSymlink
+	case TypeChar:
+		return fs.ModeDevice | fs.ModeCharDevice
+	case TypeBlock:
+		return fs.ModeDevice
+	case TypeFifo:
+		return fs.ModeNamedPipe
+	case TypeOther:
+		return fs.ModeIrregular
+	default:
+		return 0
+	}
+}
+
+type fsFile struct {
+	info fileInfo
+	rc   io.ReadCloser
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *fsFile) Read(p []byte) (int, error) { return f.rc.Read(p) }
+func (f *fsFile) Close() error               { return f.rc.Close() }
+
+type fsDir struct {
+	fs       *FS
+	info     fileInfo
+	children []string
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.h.Name, Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) Close() error { return nil }
+
+// ReadDir returns the next n entries of the directory, or all the remaining
+// ones when n <= 0.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 || n >= len(d.children) {
+		if n > 0 && len(d.children) == 0 {
+			return nil, io.EOF
+		}
+		entries := d.fs.dirEntries(d.children)
+		d.children = nil
+		return entries, nil
+	}
+	entries := d.fs.dirEntries(d.children[:n])
+	d.children = d.children[n:]
+	return entries, nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readAll reads the whole content returned by open.
+func readAll(open func() (io.ReadCloser, error)) ([]byte, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
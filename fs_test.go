@@ -0,0 +1,181 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/kumose-go/archive/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFS(t *testing.T) {
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	src := fstest.MapFS{
+		"templates/index.html":     {Data: []byte("<html></html>"), Mode: 0o644, ModTime: mtime},
+		"templates/partials/a.tpl": {Data: []byte("{{ .A }}"), Mode: 0o644, ModTime: mtime},
+		"templates/current":        {Data: []byte("partials"), Mode: fs.ModeSymlink | 0o777, ModTime: mtime},
+		"static/app.js":            {Data: bytes.Repeat([]byte("js;"), 1000), Mode: 0o644, ModTime: mtime},
+		"static/empty":             {Mode: fs.ModeDir | 0o755, ModTime: mtime},
+	}
+
+	for name, format := range map[string]string{
+		"tar":              "tar",
+		"tar.gz":           "tar.gz",
+		"tar.gz seekable":  "tar.gz",
+		"tar.xz":           "tar.xz",
+		"tar.zst":          "tar.zst",
+		"tar.zst seekable": "tar.zst",
+		"zip":              "zip",
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "archive"))
+			require.NoError(t, err)
+			defer f.Close()
+			opts := config.CreateOptions{Duplicates: "replace"}
+			if strings.HasSuffix(name, "seekable") {
+				opts.SeekableBlockSize = 512
+			}
+			archive, err := NewWithOptions(f, format, opts)
+			require.NoError(t, err)
+			// parents are only archived for templates, the others are
+			// synthesized.
+			require.NoError(t, AddTree(archive, src, config.File{Source: "templates", Destination: "release/templates"}))
+			for _, name := range []string{"static/app.js", "static/empty"} {
+				require.NoError(t, AddFS(archive, src, config.File{Source: name, Destination: "release/" + name}))
+			}
+			require.NoError(t, AddBytes(archive, config.File{Destination: "release/static/app.js", Info: config.FileInfo{ParsedMTime: mtime}}, []byte("replaced")))
+			require.NoError(t, AddBytes(archive, config.File{Destination: "../escape.txt"}, nil))
+			// regular files in a row, each following one with content.
+			for _, name := range []string{"1.txt", "2.txt", "3.txt"} {
+				require.NoError(t, AddBytes(archive, config.File{Destination: "release/docs/" + name}, []byte("doc "+name)))
+			}
+			require.NoError(t, archive.Close())
+
+			info, err := f.Stat()
+			require.NoError(t, err)
+			fsys, err := NewFS(f, info.Size(), format)
+			require.NoError(t, err)
+
+			require.NoError(t, fstest.TestFS(fsys,
+				"release/templates/index.html",
+				"release/templates/partials/a.tpl",
+				"release/templates/current",
+				"release/static/app.js",
+				"release/static/empty",
+				"release/docs/1.txt",
+				"release/docs/2.txt",
+				"release/docs/3.txt",
+			))
+			for _, name := range []string{"3.txt", "1.txt", "2.txt"} {
+				content, err := fs.ReadFile(fsys, "release/docs/"+name)
+				require.NoError(t, err)
+				require.Equal(t, "doc "+name, string(content))
+			}
+
+			content, err := fs.ReadFile(fsys, "release/templates/current/a.tpl")
+			require.NoError(t, err)
+			require.Equal(t, "{{ .A }}", string(content))
+			content, err = fs.ReadFile(fsys, "release/static/app.js")
+			require.NoError(t, err)
+			require.Equal(t, "replaced", string(content))
+
+			link, err := fs.ReadLink(fsys, "release/templates/current")
+			require.NoError(t, err)
+			require.Equal(t, "partials", link)
+			linfo, err := fs.Lstat(fsys, "release/templates/current")
+			require.NoError(t, err)
+			require.Equal(t, fs.ModeSymlink, linfo.Mode().Type())
+			sinfo, err := fs.Stat(fsys, "release/templates/current")
+			require.NoError(t, err)
+			require.True(t, sinfo.IsDir())
+
+			matches, err := fs.Glob(fsys, "release/templates/*.html")
+			require.NoError(t, err)
+			require.Equal(t, []string{"release/templates/index.html"}, matches)
+
+			_, err = fsys.Open("escape.txt")
+			require.ErrorIs(t, err, fs.ErrNotExist)
+			_, err = fsys.Open("../escape.txt")
+			require.ErrorIs(t, err, fs.ErrInvalid)
+		})
+	}
+}
+
+func TestFSTarHardlink(t *testing.T) {
+	files := []string{"a.txt", "b.txt"}
+	folder := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(folder, files[0]), []byte("shared"), 0o644))
+	if err := os.Link(filepath.Join(folder, files[0]), filepath.Join(folder, files[1])); err != nil {
+		t.Skip("hardlinks are not supported:", err)
+	}
+
+	var buf bytes.Buffer
+	archive, err := New(&buf, "tar")
+	require.NoError(t, err)
+	for _, name := range files {
+		require.NoError(t, archive.Add(config.File{Source: filepath.Join(folder, name), Destination: name}))
+	}
+	require.NoError(t, archive.Close())
+
+	fsys, err := NewFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "tar")
+	require.NoError(t, err)
+	content, err := fs.ReadFile(fsys, "b.txt")
+	require.NoError(t, err)
+	require.Equal(t, "shared", string(content))
+	info, err := fs.Stat(fsys, "b.txt")
+	require.NoError(t, err)
+	require.Equal(t, int64(6), info.Size())
+
+	_, err = NewFS(bytes.NewReader(nil), 0, "gz")
+	require.ErrorContains(t, err, "unsupported archive format: gz")
+}
+
+func TestFSTarOffsets(t *testing.T) {
+	for name, opts := range map[string]config.CreateOptions{
+		"tar":             {},
+		"tar.gz":          {},
+		"tar.gz seekable": {SeekableBlockSize: 100},
+	} {
+		t.Run(name, func(t *testing.T) {
+			format := strings.TrimSuffix(name, " seekable")
+			var buf bytes.Buffer
+			archive, err := NewWithOptions(&buf, format, opts)
+			require.NoError(t, err)
+			for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+				require.NoError(t, AddBytes(archive, config.File{Destination: name}, bytes.Repeat([]byte(name), 200)))
+			}
+			require.NoError(t, archive.Close())
+
+			fsys, err := NewFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()), format)
+			require.NoError(t, err)
+			for _, name := range []string{"c.txt", "a.txt", "b.txt"} {
+				content, err := fs.ReadFile(fsys, name)
+				require.NoError(t, err)
+				require.Equal(t, bytes.Repeat([]byte(name), 200), content)
+			}
+		})
+	}
+}
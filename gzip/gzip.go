@@ -18,39 +18,101 @@
 package gzip
 
 import (
+	"bytes"
 	"fmt"
 	"io"
-	"os"
+	"io/fs"
+	"runtime"
+	"sync"
+	"time"
 
 	gzip "github.com/klauspost/pgzip"
 	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/internal/checksum"
+	"github.com/kumose-go/archive/internal/names"
+	"github.com/kumose-go/archive/internal/osutil"
+	"github.com/kumose-go/archive/internal/sized"
 )
 
 // Archive as gz.
 type Archive struct {
-	gw *gzip.Writer
+	gw   *gzip.Writer
+	sums *checksum.Recorder
+	mu   *sync.Mutex
+	// added is set once the file is added, whatever its name.
+	added *bool
 }
 
+// minBlockSize is the smallest block size accepted by the parallel writer.
+const minBlockSize = 32 * 1024
+
 // New gz archive.
 func New(target io.Writer) Archive {
+	return NewWithOptions(target, config.CreateOptions{})
+}
+
+// NewWithOptions creates a gz archive written according to opts.
+func NewWithOptions(target io.Writer, opts config.CreateOptions) Archive {
+	return Archive{
+		gw:    NewWriter(target, opts),
+		sums:  checksum.NewRecorder(opts.Checksums),
+		mu:    &sync.Mutex{},
+		added: new(bool),
+	}
+}
+
+// NewWriter returns a gzip writer at best compression, compressing blocks of
+// its input in parallel as configured by opts. The output is a standard gzip
+// stream, whose header holds the comment and extra field of opts.Metadata.
+func NewWriter(target io.Writer, opts config.CreateOptions) *gzip.Writer {
 	// the error will be nil since the compression level is valid
 	gw, _ := gzip.NewWriterLevel(target, gzip.BestCompression)
-	return Archive{
-		gw: gw,
+	gw.Comment = opts.Metadata.Comment
+	gw.Extra = opts.Metadata.Extra
+	if opts.GzipBlockSize > 0 || opts.GzipConcurrency > 0 {
+		blockSize, blocks := 1<<20, runtime.GOMAXPROCS(0)
+		if opts.GzipBlockSize > 0 {
+			blockSize = max(opts.GzipBlockSize, minBlockSize)
+		}
+		if opts.GzipConcurrency > 0 {
+			blocks = opts.GzipConcurrency
+		}
+		// the error will be nil since both values are valid
+		_ = gw.SetConcurrency(blockSize, blocks)
 	}
+	return gw
 }
 
 // Close all closeables.
 func (a Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	return a.gw.Close()
 }
 
+// Checksums returns the digest of the file added, if any, computed with the
+// algorithms set in the options.
+func (a Archive) Checksums() []config.Checksum {
+	return a.sums.Checksums()
+}
+
 // Add file to the archive.
 func (a Archive) Add(f config.File) error {
-	if a.gw.Name != "" {
+	return a.AddFS(osutil.FS{}, f)
+}
+
+// AddFS adds the file f.Source of fsys to the archive.
+func (a Archive) AddFS(fsys fs.FS, f config.File) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if *a.added {
 		return fmt.Errorf("gzip: failed to add %s, only one file can be archived in gz format", f.Destination)
 	}
-	file, err := os.Open(f.Source) // #nosec
+	// symlinks are always followed, as gz holds a single file content.
+	if _, _, err := osutil.Lstat(fsys, f.Source, true, true); err != nil {
+		return fmt.Errorf("%s: %w", f.Source, err)
+	}
+	file, err := fsys.Open(f.Source)
 	if err != nil {
 		return err
 	}
@@ -62,12 +124,53 @@ func (a Archive) Add(f config.File) error {
 	if info.IsDir() {
 		return nil
 	}
+	sum, err := a.sums.Hasher()
+	if err != nil {
+		return err
+	}
+	*a.added = true
 	a.gw.Name = f.Destination
 	if f.Info.ParsedMTime.IsZero() {
 		a.gw.ModTime = info.ModTime()
 	} else {
 		a.gw.ModTime = f.Info.ParsedMTime
 	}
-	_, err = io.Copy(a.gw, file)
-	return err
+	if _, err := io.Copy(a.gw, io.TeeReader(file, sum)); err != nil {
+		return err
+	}
+	a.sums.Record(names.Normalize(f.Destination), sum)
+	return nil
+}
+
+// AddReader adds a file whose content is read from r.
+// The gzip header takes its name from f.Destination and its modification
+// time from f.Info; f.Source is ignored. It is an error for r to yield fewer
+// or more than size bytes, unless size is negative.
+func (a Archive) AddReader(f config.File, size int64, r io.Reader) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if *a.added {
+		return fmt.Errorf("gzip: failed to add %s, only one file can be archived in gz format", f.Destination)
+	}
+	sum, err := a.sums.Hasher()
+	if err != nil {
+		return err
+	}
+	*a.added = true
+	a.gw.Name = f.Destination
+	if f.Info.ParsedMTime.IsZero() {
+		a.gw.ModTime = time.Now()
+	} else {
+		a.gw.ModTime = f.Info.ParsedMTime
+	}
+	if _, err := io.Copy(a.gw, io.TeeReader(sized.Reader(r, size), sum)); err != nil {
+		return err
+	}
+	a.sums.Record(names.Normalize(f.Destination), sum)
+	return nil
+}
+
+// AddBytes adds a file with the given content to the archive.
+func (a Archive) AddBytes(f config.File, content []byte) error {
+	return a.AddReader(f, int64(len(content)), bytes.NewReader(content))
 }
@@ -18,6 +18,7 @@
 package gzip
 
 import (
+	"bytes"
 	"compress/gzip"
 	"io"
 	"os"
@@ -101,3 +102,57 @@ func TestGzFileCustomMtime(t *testing.T) {
 	require.Equal(t, "sub1/sub2/subfoo.txt", gzf.Name)
 	require.Equal(t, now, gzf.ModTime)
 }
+
+func TestGzAddBytes(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.gz"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive := New(f)
+	defer archive.Close()
+
+	now := time.Now().Truncate(time.Second)
+
+	require.NoError(t, archive.AddBytes(config.File{
+		Destination: "version",
+		Info: config.FileInfo{
+			ParsedMTime: now,
+		},
+	}, []byte("v1.0.0")))
+	require.EqualError(t, archive.AddBytes(config.File{
+		Destination: "other",
+	}, []byte("v2.0.0")), "gzip: failed to add other, only one file can be archived in gz format")
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	f, err = os.Open(f.Name())
+	require.NoError(t, err)
+	defer f.Close()
+
+	gzf, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	defer gzf.Close()
+
+	require.Equal(t, "version", gzf.Name)
+	require.Equal(t, now, gzf.ModTime)
+	bts, err := io.ReadAll(gzf)
+	require.NoError(t, err)
+	require.Equal(t, "v1.0.0", string(bts))
+}
+
+func TestGzAddUnnamed(t *testing.T) {
+	var buf bytes.Buffer
+	archive := New(&buf)
+	defer archive.Close()
+
+	require.NoError(t, archive.AddBytes(config.File{}, []byte("first")))
+	require.ErrorContains(t, archive.AddBytes(config.File{}, []byte("second")), "only one file can be archived in gz format")
+	require.ErrorContains(t, archive.Add(config.File{Source: "../testdata/foo.txt"}), "only one file can be archived in gz format")
+	require.NoError(t, archive.Close())
+
+	gzf, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	defer gzf.Close()
+	bts, err := io.ReadAll(gzf)
+	require.NoError(t, err)
+	require.Equal(t, "first", string(bts))
+}
@@ -25,6 +25,7 @@ import (
 	"path/filepath"
 
 	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/internal/osutil"
 )
 
 // ExtractGzip extracts a .gz file to the destination directory.
@@ -81,5 +82,19 @@ func ExtractGzip(src, dest string, opts config.ExtractOptions) error {
 		return err
 	}
 
+	if opts.PreserveMTime && !gzReader.ModTime.IsZero() {
+		return osutil.Chtimes(targetPath, gzReader.ModTime)
+	}
+
 	return nil
 }
+
+// ReadMetadata reads the comment and extra field of the gzip header of r.
+func ReadMetadata(r io.Reader) (config.Metadata, error) {
+	gzReader, err := gzip.NewReader(r)
+	if err != nil {
+		return config.Metadata{}, err
+	}
+	defer gzReader.Close()
+	return config.Metadata{Comment: gzReader.Comment, Extra: gzReader.Extra}, nil
+}
@@ -0,0 +1,180 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kumose-go/archive/internal/names"
+)
+
+// Risk is a reason for an entry to be suspicious.
+type Risk string
+
+// Risks reported by Info.
+const (
+	RiskAbsolute Risk = "absolute path"
+	RiskParent   Risk = "parent directory reference"
+	RiskLink     Risk = "link outside the archive"
+	RiskSetuid   Risk = "setuid"
+	RiskSetgid   Risk = "setgid"
+	RiskDevice   Risk = "device file"
+)
+
+// Suspicious is an entry that may harm the system it is extracted on.
+type Suspicious struct {
+	Name  string
+	Risks []Risk
+}
+
+// Summary describes an archive, as returned by Info.
+type Summary struct {
+	Format  string            // format of the archive, as detected with Auto
+	Entries map[EntryType]int // number of entries by type
+
+	Size           int64   // total size of the regular files
+	CompressedSize int64   // size of the archive
+	Ratio          float64 // Size divided by CompressedSize, 0 for empty archives
+
+	// TopDir is the directory holding every entry, when there is a single
+	// one, which ExtractOptions.StripTopDir removes.
+	TopDir string
+
+	Oldest time.Time // earliest modification time, zero without any
+	Newest time.Time // latest modification time, zero without any
+
+	Suspicious []Suspicious // in archive order
+}
+
+// Info returns the summary of the source archive, read according to format
+// or Auto.
+func Info(src, format string) (Summary, error) {
+	if format == Auto {
+		var err error
+		if format, err = DetectFile(src); err != nil {
+			return Summary{}, err
+		}
+	}
+	stat, err := os.Stat(src)
+	if err != nil {
+		return Summary{}, err
+	}
+	entries, err := List(src, format)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	s := Summary{
+		Format:         format,
+		Entries:        map[EntryType]int{},
+		CompressedSize: stat.Size(),
+	}
+	single := true
+	for _, e := range entries {
+		s.Entries[e.Type]++
+		if e.Type == TypeFile {
+			s.Size += e.Size
+		}
+		if !e.ModTime.IsZero() {
+			if s.Oldest.IsZero() || e.ModTime.Before(s.Oldest) {
+				s.Oldest = e.ModTime
+			}
+			if e.ModTime.After(s.Newest) {
+				s.Newest = e.ModTime
+			}
+		}
+		if risks := entryRisks(e.Header); len(risks) > 0 {
+			s.Suspicious = append(s.Suspicious, Suspicious{Name: e.Name, Risks: risks})
+		}
+
+		name := names.Normalize(e.Name)
+		if name == "" {
+			continue
+		}
+		top, _, below := strings.Cut(name, "/")
+		switch {
+		case !below && e.Type != TypeDir:
+			// a file at the top level.
+			single = false
+		case s.TopDir == "":
+			s.TopDir = top
+		case s.TopDir != top:
+			single = false
+		}
+	}
+	if !single {
+		s.TopDir = ""
+	}
+	if s.CompressedSize > 0 {
+		s.Ratio = float64(s.Size) / float64(s.CompressedSize)
+	}
+	return s, nil
+}
+
+// entryRisks returns the reasons for the entry of h to be suspicious.
+func entryRisks(h Header) []Risk {
+	var risks []Risk
+	if isAbs(h.Name) {
+		risks = append(risks, RiskAbsolute)
+	}
+	if hasParent(h.Name) {
+		risks = append(risks, RiskParent)
+	}
+	if (h.Type == TypeSymlink || h.Type == TypeHardlink) && h.Linkname != "" {
+		target := strings.ReplaceAll(h.Linkname, "\\", "/")
+		if h.Type == TypeSymlink {
+			// symlinks are relative to their directory.
+			target = path.Join(path.Dir(strings.ReplaceAll(h.Name, "\\", "/")), target)
+		}
+		if isAbs(h.Linkname) || hasParent(path.Clean(target)) {
+			risks = append(risks, RiskLink)
+		}
+	}
+	if h.Mode&fs.ModeSetuid != 0 {
+		risks = append(risks, RiskSetuid)
+	}
+	if h.Mode&fs.ModeSetgid != 0 {
+		risks = append(risks, RiskSetgid)
+	}
+	if h.Type == TypeChar || h.Type == TypeBlock {
+		risks = append(risks, RiskDevice)
+	}
+	return risks
+}
+
+// isAbs reports whether name is absolute, on Unix or Windows.
+func isAbs(name string) bool {
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") {
+		return true
+	}
+	return len(name) >= 2 && name[1] == ':' && ('a' <= name[0]|0x20 && name[0]|0x20 <= 'z')
+}
+
+// hasParent reports whether name has a ".." component.
+func hasParent(name string) bool {
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"archive/tar"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/kumose-go/archive/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfo(t *testing.T) {
+	oldest := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	newest := oldest.Add(48 * time.Hour)
+	fsys := fstest.MapFS{
+		".":         {Mode: fs.ModeDir | 0o755, ModTime: oldest},
+		"bin":       {Mode: fs.ModeDir | 0o755, ModTime: oldest},
+		"bin/tool":  {Data: []byte("#!/bin/sh\necho hello\n"), Mode: 0o755, ModTime: oldest},
+		"bin/alias": {Data: []byte("tool"), Mode: fs.ModeSymlink | 0o777, ModTime: newest},
+		"README.md": {Data: []byte("# readme\n"), Mode: 0o644, ModTime: oldest.Add(time.Hour)},
+	}
+
+	for _, format := range []string{"tar", "tar.gz", "tar.xz", "tar.zst", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "release")
+			f, err := os.Create(src)
+			require.NoError(t, err)
+			defer f.Close()
+			archive, err := New(f, format)
+			require.NoError(t, err)
+			require.NoError(t, AddTree(archive, fsys, config.File{Source: ".", Destination: "release-1.0"}))
+			require.NoError(t, archive.Close())
+			require.NoError(t, f.Close())
+			stat, err := os.Stat(src)
+			require.NoError(t, err)
+
+			info, err := Info(src, Auto)
+			require.NoError(t, err)
+			require.Equal(t, format, info.Format)
+			require.Equal(t, map[EntryType]int{TypeDir: 2, TypeFile: 2, TypeSymlink: 1}, info.Entries)
+			require.Equal(t, int64(30), info.Size)
+			require.Equal(t, stat.Size(), info.CompressedSize)
+			require.InDelta(t, 30/float64(stat.Size()), info.Ratio, 1e-9)
+			require.Equal(t, "release-1.0", info.TopDir)
+			require.True(t, oldest.Equal(info.Oldest))
+			require.True(t, newest.Equal(info.Newest))
+			require.Empty(t, info.Suspicious)
+		})
+	}
+}
+
+func TestInfoSuspicious(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "evil.tar")
+	f, err := os.Create(src)
+	require.NoError(t, err)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, h := range []*tar.Header{
+		{Name: "release/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "release/bin/su", Typeflag: tar.TypeReg, Mode: 0o4755},
+		{Name: "release/bin/group", Typeflag: tar.TypeReg, Mode: 0o2755},
+		{Name: "release/dev/null", Typeflag: tar.TypeChar, Mode: 0o666, Devmajor: 1, Devminor: 3},
+		{Name: "release/etc", Typeflag: tar.TypeSymlink, Linkname: "../../etc", Mode: 0o777},
+		{Name: "release/ok", Typeflag: tar.TypeSymlink, Linkname: "../release/bin", Mode: 0o777},
+		{Name: "/etc/passwd", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "release/../../escape", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "release/shadow", Typeflag: tar.TypeLink, Linkname: "/etc/shadow"},
+	} {
+		h.ModTime = time.Unix(1700000000, 0)
+		require.NoError(t, tw.WriteHeader(h))
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, f.Close())
+
+	info, err := Info(src, "tar")
+	require.NoError(t, err)
+	require.Equal(t, []Suspicious{
+		{Name: "release/bin/su", Risks: []Risk{RiskSetuid}},
+		{Name: "release/bin/group", Risks: []Risk{RiskSetgid}},
+		{Name: "release/dev/null", Risks: []Risk{RiskDevice}},
+		{Name: "release/etc", Risks: []Risk{RiskLink}},
+		{Name: "/etc/passwd", Risks: []Risk{RiskAbsolute}},
+		{Name: "release/../../escape", Risks: []Risk{RiskParent}},
+		{Name: "release/shadow", Risks: []Risk{RiskLink}},
+	}, info.Suspicious)
+	// the absolute path lies outside the release directory.
+	require.Empty(t, info.TopDir)
+	require.Equal(t, time.Unix(1700000000, 0), info.Oldest.Local())
+}
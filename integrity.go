@@ -0,0 +1,153 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/pgzip"
+)
+
+// Damage is a damaged part of an archive, found by Test.
+type Damage struct {
+	Name   string // entry holding the damage, empty outside entries
+	Offset int64  // offset of the entry, or of the damage outside entries, -1 when unknown
+	Err    error
+}
+
+func (d Damage) Error() string {
+	switch {
+	case d.Offset < 0 && d.Name == "":
+		return d.Err.Error()
+	case d.Offset < 0:
+		return fmt.Sprintf("%s: %v", d.Name, d.Err)
+	case d.Name == "":
+		return fmt.Sprintf("at offset %d: %v", d.Offset, d.Err)
+	}
+	return fmt.Sprintf("%s at offset %d: %v", d.Name, d.Offset, d.Err)
+}
+
+func (d Damage) Unwrap() error {
+	return d.Err
+}
+
+// Test fully decodes the source archive, according to format or Auto,
+// without writing anything, and returns the damage found: zip entries whose
+// content does not match its CRC32, gzip, xz and zstd streams failing their
+// checksums, tar headers failing theirs, and truncated archives. The offset
+// of zip entries is the one of their content in the archive, the one of tar
+// entries the one of their header in the tar stream, once decompressed.
+// Decoding tar archives stops at the first damage, as the entries after it
+// cannot be located. An error is returned when src cannot be read.
+func Test(src, format string) ([]Damage, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if format == Auto {
+		if format, err = detectFile(f); err != nil {
+			return nil, err
+		}
+	}
+
+	switch format {
+	case "zip":
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return testZip(f, info.Size()), nil
+	case "gz":
+		return testGz(f), nil
+	case "tar.gz", "tgz", "tar", "tar.xz", "txz", "tar.zst", "tzst":
+		// the stream is decoded as a whole, not through a seek table.
+		return testTar(bufio.NewReader(f), format), nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+func testZip(r io.ReaderAt, size int64) []Damage {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return []Damage{{Offset: -1, Err: err}}
+	}
+	var damage []Damage
+	for _, zf := range zr.File {
+		offset, err := zf.DataOffset()
+		if err != nil {
+			damage = append(damage, Damage{Name: zf.Name, Offset: -1, Err: err})
+			continue
+		}
+		if _, err := readAll(zf.Open); err != nil {
+			damage = append(damage, Damage{Name: zf.Name, Offset: offset, Err: err})
+		}
+	}
+	return damage
+}
+
+func testGz(r io.Reader) []Damage {
+	gzr, err := pgzip.NewReader(r)
+	if err != nil {
+		return []Damage{{Offset: -1, Err: err}}
+	}
+	defer gzr.Close()
+	if _, err := io.Copy(io.Discard, gzr); err != nil {
+		return []Damage{{Name: gzr.Name, Offset: -1, Err: err}}
+	}
+	return nil
+}
+
+func testTar(r io.Reader, format string) []Damage {
+	rc, err := decompress(r, format)
+	if err != nil {
+		return []Damage{{Offset: -1, Err: err}}
+	}
+	defer rc.Close()
+
+	counter := &countingReader{r: rc}
+	w := &tarWalker{tr: tar.NewReader(counter), pos: func() int64 { return counter.n }}
+	for {
+		h, start, err := w.next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			_, err = io.Copy(io.Discard, w.tr)
+		}
+		if err != nil {
+			damage := Damage{Offset: start, Err: err}
+			if h != nil {
+				damage.Name = h.Name
+			}
+			return []Damage{damage}
+		}
+	}
+	// the checksums of compressed streams follow the end of the archive.
+	start := counter.n
+	if _, err := io.Copy(io.Discard, counter); err != nil {
+		return []Damage{{Offset: start, Err: err}}
+	}
+	return nil
+}
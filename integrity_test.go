@@ -0,0 +1,139 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/pgzip"
+	"github.com/kumose-go/archive/config"
+	"github.com/stretchr/testify/require"
+)
+
+// testArchive returns an archive in format holding a.txt and b.txt.
+func testArchive(t *testing.T, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive, err := New(&buf, format)
+	require.NoError(t, err)
+	require.NoError(t, AddBytes(archive, config.File{Destination: "a.txt", Method: "store"}, []byte("aaa")))
+	if format != "gz" {
+		content := bytes.Repeat([]byte("some content of b.txt\n"), 100)
+		require.NoError(t, AddBytes(archive, config.File{Destination: "b.txt", Method: "store"}, content))
+	}
+	require.NoError(t, archive.Close())
+	return buf.Bytes()
+}
+
+// writeArchive writes bts to a file named name.
+func writeArchive(t *testing.T, name string, bts []byte) string {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(src, bts, 0o644))
+	return src
+}
+
+func TestTestIntact(t *testing.T) {
+	for _, format := range []string{"tar", "tar.gz", "tar.xz", "tar.zst", "zip", "gz"} {
+		t.Run(format, func(t *testing.T) {
+			damage, err := Test(writeArchive(t, "archive", testArchive(t, format)), Auto)
+			require.NoError(t, err)
+			require.Empty(t, damage)
+		})
+	}
+}
+
+func TestTestZip(t *testing.T) {
+	bts := testArchive(t, "zip")
+	offset := bytes.Index(bts, []byte("some content"))
+	require.Positive(t, offset)
+	bts[offset+5] ^= 0xff
+
+	damage, err := Test(writeArchive(t, "archive.zip", bts), "zip")
+	require.NoError(t, err)
+	require.Len(t, damage, 1)
+	require.Equal(t, "b.txt", damage[0].Name)
+	require.Equal(t, int64(offset), damage[0].Offset)
+	require.ErrorIs(t, damage[0], zip.ErrChecksum)
+
+	damage, err = Test(writeArchive(t, "archive.zip", bts[:len(bts)-10]), "zip")
+	require.NoError(t, err)
+	require.Len(t, damage, 1)
+	require.Equal(t, int64(-1), damage[0].Offset)
+}
+
+func TestTestTar(t *testing.T) {
+	bts := testArchive(t, "tar")
+	// a.txt takes a header and a content block.
+	corrupted := bytes.Clone(bts)
+	corrupted[1024] = 'c'
+	damage, err := Test(writeArchive(t, "archive.tar", corrupted), "tar")
+	require.NoError(t, err)
+	require.Len(t, damage, 1)
+	require.Equal(t, int64(1024), damage[0].Offset)
+	require.ErrorIs(t, damage[0], tar.ErrHeader)
+
+	damage, err = Test(writeArchive(t, "archive.tar", bts[:1024+512+100]), "tar")
+	require.NoError(t, err)
+	require.Len(t, damage, 1)
+	require.Equal(t, "b.txt", damage[0].Name)
+	require.Equal(t, int64(1024), damage[0].Offset)
+	require.ErrorIs(t, damage[0], io.ErrUnexpectedEOF)
+	require.Equal(t, "b.txt at offset 1024: unexpected EOF", damage[0].Error())
+}
+
+func TestTestCompressed(t *testing.T) {
+	// the CRC32 of the content ends gzip streams, before its size.
+	bts := testArchive(t, "tar.gz")
+	bts[len(bts)-8] ^= 0xff
+	damage, err := Test(writeArchive(t, "archive.tar.gz", bts), "tar.gz")
+	require.NoError(t, err)
+	require.Len(t, damage, 1)
+	require.Empty(t, damage[0].Name)
+	require.ErrorIs(t, damage[0], pgzip.ErrChecksum)
+
+	for _, format := range []string{"tar.xz", "tar.zst", "gz"} {
+		t.Run(format, func(t *testing.T) {
+			bts := testArchive(t, format)
+			damage, err := Test(writeArchive(t, "archive", bts[:len(bts)-6]), format)
+			require.NoError(t, err)
+			require.Len(t, damage, 1)
+		})
+	}
+
+	// the offset of gzip errors is unknown.
+	bts = testArchive(t, "gz")
+	damage, err = Test(writeArchive(t, "archive.gz", bts[:len(bts)-6]), "gz")
+	require.NoError(t, err)
+	require.Len(t, damage, 1)
+	require.Equal(t, int64(-1), damage[0].Offset)
+	for _, format := range []string{"gz", "tar.gz"} {
+		damage, err = Test(writeArchive(t, "archive", bytes.Repeat([]byte("not gzip "), 4)), format)
+		require.NoError(t, err)
+		require.Len(t, damage, 1)
+		require.Equal(t, int64(-1), damage[0].Offset, format)
+		require.ErrorIs(t, damage[0], pgzip.ErrHeader, format)
+		require.Equal(t, pgzip.ErrHeader.Error(), damage[0].Error(), format)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+// Package checksum hashes the content of archive entries while they are
+// written, and lists the resulting digests.
+package checksum
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/kumose-go/archive/config"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Algorithms, see config.CreateOptions.
+const (
+	SHA256  = "sha256"
+	SHA512  = "sha512"
+	BLAKE2b = "blake2b"
+)
+
+// newHash returns a hash computing a digest with algorithm.
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	case BLAKE2b:
+		// the error will be nil since no key is given.
+		return blake2b.New512(nil)
+	default:
+		return nil, fmt.Errorf("invalid checksum algorithm: %s", algorithm)
+	}
+}
+
+// Hasher computes the digests of a content with several algorithms. A
+// Hasher without algorithm discards what is written to it.
+type Hasher struct {
+	algorithms []string
+	hashes     []hash.Hash
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	for _, hh := range h.hashes {
+		// writing to a hash never fails.
+		_, _ = hh.Write(p)
+	}
+	return len(p), nil
+}
+
+// WriteZeros hashes n zero bytes, such as the holes of sparse files.
+func (h *Hasher) WriteZeros(n int64) {
+	if len(h.hashes) == 0 {
+		return
+	}
+	zeros := make([]byte, min(n, 32*1024))
+	for n > 0 {
+		chunk := min(n, int64(len(zeros)))
+		_, _ = h.Write(zeros[:chunk])
+		n -= chunk
+	}
+}
+
+// digests returns the hex encoded digests of the content, by algorithm.
+func (h *Hasher) digests() map[string]string {
+	digests := make(map[string]string, len(h.hashes))
+	for i, hh := range h.hashes {
+		digests[h.algorithms[i]] = hex.EncodeToString(hh.Sum(nil))
+	}
+	return digests
+}
+
+// Recorder records the digests of the entries of an archive. It is safe for
+// concurrent use, and a nil Recorder records nothing.
+type Recorder struct {
+	algorithms []string
+	mu         sync.Mutex
+	digests    map[string]map[string]string
+}
+
+// NewRecorder returns a Recorder hashing with algorithms, or nil when
+// there are none. Invalid algorithms are reported by Hasher.
+func NewRecorder(algorithms []string) *Recorder {
+	if len(algorithms) == 0 {
+		return nil
+	}
+	return &Recorder{algorithms: algorithms, digests: map[string]map[string]string{}}
+}
+
+// Hasher returns a Hasher for the content of an entry.
+func (r *Recorder) Hasher() (*Hasher, error) {
+	if r == nil {
+		return &Hasher{}, nil
+	}
+	h := &Hasher{algorithms: r.algorithms}
+	for _, algorithm := range r.algorithms {
+		hh, err := newHash(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		h.hashes = append(h.hashes, hh)
+	}
+	return h, nil
+}
+
+// Record records the digests of h as the ones of the entry name, replacing
+// the ones of a previous entry of that name.
+func (r *Recorder) Record(name string, h *Hasher) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.digests[name] = h.digests()
+}
+
+// Link records the digests of the entry target, if any, as the ones of the
+// entry name, a hardlink to it.
+func (r *Recorder) Link(name, target string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if digests, ok := r.digests[target]; ok {
+		r.digests[name] = digests
+	}
+}
+
+// Checksums returns the digests recorded, sorted by entry name.
+func (r *Recorder) Checksums() []config.Checksum {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	checksums := make([]config.Checksum, 0, len(r.digests))
+	for _, name := range slices.Sorted(maps.Keys(r.digests)) {
+		checksums = append(checksums, config.Checksum{Name: name, Digests: maps.Clone(r.digests[name])})
+	}
+	return checksums
+}
+
+// Manifest returns the listing of the digests recorded: the one of
+// sha256sum with a single algorithm, the tagged one of sha256sum --tag
+// otherwise.
+func (r *Recorder) Manifest() []byte {
+	var sb strings.Builder
+	for _, c := range r.Checksums() {
+		if len(r.algorithms) == 1 {
+			fmt.Fprintf(&sb, "%s  %s\n", c.Digests[r.algorithms[0]], c.Name)
+			continue
+		}
+		for _, algorithm := range r.algorithms {
+			fmt.Fprintf(&sb, "%s (%s) = %s\n", tag(algorithm), c.Name, c.Digests[algorithm])
+		}
+	}
+	return []byte(sb.String())
+}
+
+// tag returns the name of algorithm in tagged listings, as spelled by
+// sha256sum and b2sum.
+func tag(algorithm string) string {
+	if algorithm == BLAKE2b {
+		return "BLAKE2b"
+	}
+	return strings.ToUpper(algorithm)
+}
@@ -0,0 +1,74 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+// Package names normalizes the names of archive entries and tracks the ones
+// already archived.
+package names
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Duplicate policies, see config.CreateOptions.
+const (
+	Error   = "error"
+	Keep    = "keep"
+	Replace = "replace"
+)
+
+// Normalize returns the canonical form of an entry name: cleaned, with
+// forward slashes, and without leading "./" or "/". Directories lose their
+// trailing slash.
+func Normalize(name string) string {
+	name = path.Clean(filepath.ToSlash(name))
+	name = strings.TrimLeft(name, "/")
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+// Claim records name, which must be normalized, in files. It reports whether
+// the entry should be written according to the duplicate policy: a duplicate
+// is an error, skipped when keeping the first entry, or written again when
+// the last one replaces it. Empty names are invalid.
+func Claim(files map[string]bool, name, policy string) (bool, error) {
+	switch policy {
+	case "", Error, Keep, Replace:
+	default:
+		return false, fmt.Errorf("invalid duplicate policy: %s", policy)
+	}
+	if name == "" {
+		return false, &fs.PathError{Err: fs.ErrInvalid, Path: name, Op: "add"}
+	}
+	if !files[name] {
+		files[name] = true
+		return true, nil
+	}
+	switch policy {
+	case Keep:
+		return false, nil
+	case Replace:
+		return true, nil
+	default:
+		return false, &fs.PathError{Err: fs.ErrExist, Path: name, Op: "add"}
+	}
+}
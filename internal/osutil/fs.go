@@ -0,0 +1,82 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package osutil
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// FS gives access to the files of the operating system through their native
+// paths. Unlike os.DirFS, it is not rooted and accepts any path, so the
+// sources of archive entries can be read through the io/fs interfaces.
+type FS struct{}
+
+var _ fs.ReadLinkFS = FS{}
+
+// Open opens the named file for reading.
+func (FS) Open(name string) (fs.File, error) {
+	return os.Open(name) // #nosec
+}
+
+// Stat returns a FileInfo describing the named file, following symlinks.
+func (FS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Lstat returns a FileInfo describing the named file, without following
+// symlinks.
+func (FS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+// ReadLink returns the destination of the named symbolic link.
+func (FS) ReadLink(name string) (string, error) {
+	return os.Readlink(name) // #nosec
+}
+
+// Lstat describes the file name of fsys without following symlinks, unless
+// follow is set. link holds the target of symlinks, followed or not.
+// Dangling symlinks are reported as errors when followed or when
+// rejectDangling is set.
+func Lstat(fsys fs.FS, name string, follow, rejectDangling bool) (info fs.FileInfo, link string, err error) {
+	info, err = fs.Lstat(fsys, name)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return info, "", err
+	}
+	link, err = fs.ReadLink(fsys, name)
+	if err != nil {
+		return nil, "", err
+	}
+	if !follow && !rejectDangling {
+		return info, link, nil
+	}
+	target, err := fs.Stat(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, link, fmt.Errorf("dangling symlink to %s: %w", link, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, link, err
+	}
+	if follow {
+		return target, link, nil
+	}
+	return info, link, nil
+}
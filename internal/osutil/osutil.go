@@ -0,0 +1,99 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+// Package osutil holds the operating system helpers shared by the archive
+// formats.
+package osutil
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+)
+
+// FileID identifies a file on the system.
+type FileID struct {
+	Dev uint64
+	Ino uint64
+}
+
+// Region is a span of bytes in a file.
+type Region struct {
+	Offset int64
+	Length int64
+}
+
+// LookupUser returns the numeric id of the named user. Numeric names are
+// returned as is.
+func LookupUser(name string) (int, bool) {
+	if name == "" {
+		return 0, false
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, true
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(u.Uid)
+	return id, err == nil
+}
+
+// LookupGroup returns the numeric id of the named group. Numeric names are
+// returned as is.
+func LookupGroup(name string) (int, bool) {
+	if name == "" {
+		return 0, false
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, true
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(g.Gid)
+	return id, err == nil
+}
+
+// Chown changes the owner of path, without following symlinks. The user and
+// group names take precedence over the numeric ids when they exist on this
+// system.
+func Chown(path string, uid, gid int, uname, gname string) error {
+	if id, ok := LookupUser(uname); ok {
+		uid = id
+	}
+	if id, ok := LookupGroup(gname); ok {
+		gid = id
+	}
+	return os.Lchown(path, uid, gid)
+}
+
+// Chtimes sets the access and modification times of path to mtime.
+// Symlinks are left untouched since their times cannot be set portably.
+func Chtimes(path string, mtime time.Time) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return os.Chtimes(path, mtime, mtime)
+}
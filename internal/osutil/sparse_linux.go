@@ -0,0 +1,58 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+//go:build linux
+
+package osutil
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+const (
+	seekData = 3 // SEEK_DATA
+	seekHole = 4 // SEEK_HOLE
+)
+
+// DataRegions returns the regions of f holding data, skipping its holes.
+// It returns nil when the holes of f cannot be detected.
+func DataRegions(f *os.File, size int64) []Region {
+	defer f.Seek(0, io.SeekStart) // #nosec
+
+	regions := []Region{}
+	for off := int64(0); off < size; {
+		data, err := f.Seek(off, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			// only a hole is left.
+			break
+		}
+		if err != nil {
+			return nil
+		}
+		hole, err := f.Seek(data, seekHole)
+		if err != nil {
+			return nil
+		}
+		hole = min(hole, size)
+		regions = append(regions, Region{Offset: data, Length: hole - data})
+		off = hole
+	}
+	return regions
+}
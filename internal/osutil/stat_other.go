@@ -0,0 +1,33 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+//go:build !unix
+
+package osutil
+
+import "io/fs"
+
+// Owner returns the numeric user and group ids owning the file.
+func Owner(fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// Inode returns the identity of the file on its device and its number of
+// hard links.
+func Inode(fs.FileInfo) (id FileID, links uint64, ok bool) {
+	return FileID{}, 0, false
+}
@@ -0,0 +1,137 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+// Package seekable reads compressed streams made of independently decodable
+// blocks at random offsets of their decompressed content.
+package seekable
+
+import (
+	"errors"
+	"io"
+	"sort"
+)
+
+// Checkpoint is the start of a block, at the given offsets of the
+// compressed and of the decompressed stream.
+type Checkpoint struct {
+	Compressed   int64
+	Decompressed int64
+}
+
+// Reader reads the decompressed content of a stream of blocks, decoding it
+// from the block holding the current offset.
+type Reader struct {
+	r      io.ReaderAt
+	size   int64
+	points []Checkpoint
+	total  int64
+	open   func(io.Reader) (io.ReadCloser, error)
+
+	pos    int64         // offset of the next read
+	cur    io.ReadCloser // decoder, if any
+	curPos int64         // offset of the next byte cur yields
+}
+
+// NewReader returns a Reader over the compressed stream r of the given
+// size, holding total bytes once decompressed. points are the checkpoints
+// of the blocks, sorted, the first one at the start of the stream. open
+// returns a decoder of the stream read from its reader argument.
+func NewReader(r io.ReaderAt, size int64, points []Checkpoint, total int64, open func(io.Reader) (io.ReadCloser, error)) *Reader {
+	return &Reader{r: r, size: size, points: points, total: total, open: open}
+}
+
+// Size returns the size of the decompressed content.
+func (r *Reader) Size() int64 {
+	return r.total
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.pos >= r.total {
+		return 0, io.EOF
+	}
+	if err := r.position(); err != nil {
+		return 0, err
+	}
+	p = p[:min(int64(len(p)), r.total-r.pos)]
+	n, err := r.cur.Read(p)
+	r.pos += int64(n)
+	r.curPos += int64(n)
+	if err == io.EOF && r.pos < r.total {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// position makes cur yield the content at pos, decoding from the block
+// holding it unless cur is already positioned before pos in that block.
+func (r *Reader) position() error {
+	if r.cur != nil && r.curPos == r.pos {
+		return nil
+	}
+	block := r.block(r.pos)
+	if r.cur == nil || r.curPos > r.pos || r.block(r.curPos) != block {
+		if err := r.Close(); err != nil {
+			return err
+		}
+		point := r.points[block]
+		cur, err := r.open(io.NewSectionReader(r.r, point.Compressed, r.size-point.Compressed))
+		if err != nil {
+			return err
+		}
+		r.cur, r.curPos = cur, point.Decompressed
+	}
+	n, err := io.CopyN(io.Discard, r.cur, r.pos-r.curPos)
+	r.curPos += n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// block returns the index of the block holding offset.
+func (r *Reader) block(offset int64) int {
+	return sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].Decompressed > offset
+	}) - 1
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.total
+	default:
+		return 0, errors.New("seekable: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("seekable: negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// Close releases the current decoder.
+func (r *Reader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	err := r.cur.Close()
+	r.cur = nil
+	return err
+}
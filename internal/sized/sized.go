@@ -0,0 +1,61 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+// Package sized checks that readers hold the number of bytes announced for
+// them.
+package sized
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrTooLong is returned when a reader holds more bytes than announced.
+var ErrTooLong = errors.New("content is longer than its size")
+
+// Reader returns a reader of the content of r that fails with
+// io.ErrUnexpectedEOF when r holds fewer than size bytes, and with
+// ErrTooLong when it holds more. A negative size accepts any length.
+func Reader(r io.Reader, size int64) io.Reader {
+	if size < 0 {
+		return r
+	}
+	return &reader{r: r, left: size}
+}
+
+type reader struct {
+	r    io.Reader
+	left int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	// read one byte past the size to tell an overlong reader apart.
+	if int64(len(p)) > r.left+1 {
+		p = p[:r.left+1]
+	}
+	n, err := r.r.Read(p)
+	if int64(n) > r.left {
+		n = int(r.left)
+		r.left = 0
+		return n, ErrTooLong
+	}
+	r.left -= int64(n)
+	if err == io.EOF && r.left > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
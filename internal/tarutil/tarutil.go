@@ -0,0 +1,57 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+// Package tarutil holds the rules of the tar format shared by the writer,
+// the extractor and the readers of tar archives.
+package tarutil
+
+import (
+	"archive/tar"
+	"strings"
+)
+
+// BlockSize is the size of tar blocks, which headers and contents fill.
+const BlockSize = 512
+
+// Padding returns the number of bytes needed to pad size to a block.
+func Padding(size int64) int64 {
+	return -size & (BlockSize - 1)
+}
+
+// IsSparse reports whether the entry of header was archived as a sparse
+// file.
+func IsSparse(header *tar.Header) bool {
+	if header.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range header.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// HeaderOnly reports whether entries of the type flag have no content,
+// whatever the size in their header.
+func HeaderOnly(flag byte) bool {
+	switch flag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/kumose-go/archive/internal/names"
+)
+
+// Entry is an archive entry, as listed by List.
+// CompressedSize is -1 when unknown, as for the entries of compressed tar
+// archives. CRC32 is only recorded by zip archives.
+type Entry struct {
+	Header
+	CompressedSize int64
+	CRC32          uint32
+}
+
+// List returns the entries of the source archive, according to format, in
+// archive order. The size of gz content is computed by decompressing it.
+func List(src, format string) ([]Entry, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if format == Auto {
+		if format, err = detectFile(f); err != nil {
+			return nil, err
+		}
+	}
+
+	if format == "zip" {
+		return listZip(f)
+	}
+	r, err := Open(f, format)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var entries []Entry
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry := Entry{Header: *h, CompressedSize: -1}
+		switch format {
+		case "tar":
+			entry.CompressedSize = h.Size
+		case "gz":
+			if entry.Size, err = io.Copy(io.Discard, r); err != nil {
+				return nil, err
+			}
+			info, err := f.Stat()
+			if err != nil {
+				return nil, err
+			}
+			entry.CompressedSize = info.Size()
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// listZip lists the entries of the central directory of the zip archive f.
+func listZip(f *os.File) ([]Entry, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(zr.File))
+	for _, zf := range zr.File {
+		entry := Entry{
+			Header:         *zipHeader(zf),
+			CompressedSize: int64(zf.CompressedSize64),
+			CRC32:          zf.CRC32,
+		}
+		if entry.Type == TypeSymlink {
+			link, err := readAll(zf.Open)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", zf.Name, err)
+			}
+			entry.Linkname = string(link)
+			entry.Size = 0
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Stat returns the entry of the source archive with the given name, the
+// last one when several share it. Names are compared once normalized.
+func Stat(src, format, name string) (Entry, error) {
+	entries, err := List(src, format)
+	if err != nil {
+		return Entry{}, err
+	}
+	name = names.Normalize(name)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if names.Normalize(entries[i].Name) == name {
+			return entries[i], nil
+		}
+	}
+	return Entry{}, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
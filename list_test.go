@@ -0,0 +1,110 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/kumose-go/archive/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	content := []byte("#!/bin/sh\necho hello\n")
+	fsys := fstest.MapFS{
+		"bin":       {Mode: fs.ModeDir | 0o755, ModTime: mtime},
+		"bin/tool":  {Data: content, Mode: 0o755, ModTime: mtime},
+		"bin/alias": {Data: []byte("tool"), Mode: fs.ModeSymlink | 0o777, ModTime: mtime},
+	}
+
+	for _, format := range []string{"tar", "tar.gz", "tar.xz", "tar.zst", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "archive")
+			f, err := os.Create(name)
+			require.NoError(t, err)
+			defer f.Close()
+			archive, err := New(f, format)
+			require.NoError(t, err)
+			require.NoError(t, AddTree(archive, fsys, config.File{Source: "bin", Destination: "bin"}))
+			require.NoError(t, archive.Close())
+			require.NoError(t, f.Close())
+
+			entries, err := List(name, format)
+			require.NoError(t, err)
+			require.Len(t, entries, 3)
+			require.Equal(t, "bin", entries[0].Name)
+			require.Equal(t, TypeDir, entries[0].Type)
+			require.Equal(t, "bin/alias", entries[1].Name)
+			require.Equal(t, TypeSymlink, entries[1].Type)
+			require.Equal(t, "tool", entries[1].Linkname)
+
+			tool := entries[2]
+			require.Equal(t, "bin/tool", tool.Name)
+			require.Equal(t, TypeFile, tool.Type)
+			require.Equal(t, int64(len(content)), tool.Size)
+			require.Equal(t, fs.FileMode(0o755), tool.Mode)
+			require.Equal(t, mtime, tool.ModTime.UTC())
+			switch format {
+			case "zip":
+				require.Positive(t, tool.CompressedSize)
+				require.Equal(t, crc32.ChecksumIEEE(content), tool.CRC32)
+			case "tar":
+				require.Equal(t, tool.Size, tool.CompressedSize)
+			default:
+				require.Equal(t, int64(-1), tool.CompressedSize)
+			}
+
+			entry, err := Stat(name, format, "./bin/tool")
+			require.NoError(t, err)
+			require.Equal(t, tool, entry)
+			_, err = Stat(name, format, "bin/nope")
+			require.ErrorIs(t, err, fs.ErrNotExist)
+		})
+	}
+}
+
+func TestListGz(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "app.log.gz")
+	f, err := os.Create(name)
+	require.NoError(t, err)
+	defer f.Close()
+	archive, err := New(f, "gz")
+	require.NoError(t, err)
+	require.NoError(t, AddBytes(archive, config.File{Destination: "app.log"}, []byte("log line\n")))
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+	info, err := os.Stat(name)
+	require.NoError(t, err)
+
+	entries, err := List(name, "gz")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "app.log", entries[0].Name)
+	require.Equal(t, int64(9), entries[0].Size)
+	require.Equal(t, info.Size(), entries[0].CompressedSize)
+
+	_, err = List(filepath.Join(t.TempDir(), "nope.zip"), "zip")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
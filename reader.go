@@ -0,0 +1,377 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/kumose-go/archive/targz"
+	"github.com/kumose-go/archive/tarzst"
+	azip "github.com/kumose-go/archive/zip"
+	"github.com/ulikunitz/xz"
+)
+
+// EntryType is the type of an archive entry.
+type EntryType string
+
+// Entry types.
+const (
+	TypeFile     EntryType = "file"
+	TypeDir      EntryType = "dir"
+	TypeSymlink  EntryType = "symlink"
+	TypeHardlink EntryType = "hardlink"
+	TypeChar     EntryType = "char"
+	TypeBlock    EntryType = "block"
+	TypeFifo     EntryType = "fifo"
+	TypeOther    EntryType = "other"
+)
+
+// Header describes an archive entry, whatever the archive format.
+// Size is -1 when unknown until the content is read, as in gz files, which
+// record no mode nor owner either. Linkname is the target of symlinks and
+// hardlinks.
+type Header struct {
+	Name     string
+	Type     EntryType
+	Size     int64
+	Mode     fs.FileMode
+	ModTime  time.Time
+	Linkname string
+	UID      int
+	GID      int
+	Uname    string
+	Gname    string
+	Xattrs   map[string]string
+}
+
+// Reader reads the entries of an archive in order. Next advances to the
+// next entry, returning io.EOF at the end of the archive, and Read reads
+// the content of the current entry.
+type Reader interface {
+	io.Reader
+	Next() (*Header, error)
+	Close() error
+}
+
+// Open returns a Reader over the archive read from r, according to format,
+// or to its content with the Auto format.
+// Zip archives need random access: r is used as is when it implements
+// io.ReaderAt and io.Seeker, and is read into memory otherwise.
+func Open(r io.Reader, format string) (Reader, error) {
+	if format == Auto {
+		var err error
+		if format, r, err = detectReader(r); err != nil {
+			return nil, err
+		}
+	}
+	switch format {
+	case "tar.gz", "tgz", "tar", "tar.xz", "txz", "tar.zst", "tzst":
+		rc, err := decompress(r, format)
+		if err != nil {
+			return nil, err
+		}
+		return &tarReader{tr: tar.NewReader(rc), rc: rc}, nil
+	case "gz":
+		gzr, err := pgzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &gzReader{gzr: gzr}, nil
+	case "zip":
+		ra, size, err := readerAt(r)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(ra, size)
+		if err != nil {
+			return nil, err
+		}
+		return &zipReader{files: zr.File}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// decompress returns the tar stream of the archive read from r. When r
+// implements io.ReaderAt and io.Seeker, the stream of plain tar archives
+// and of seekable tar.gz and tar.zst ones implements io.Seeker too.
+func decompress(r io.Reader, format string) (io.ReadCloser, error) {
+	if rs, err := seekableStream(r, format); rs != nil || err != nil {
+		return rs, err
+	}
+	switch format {
+	case "tar.gz", "tgz":
+		return pgzip.NewReader(r)
+	case "tar.xz", "txz":
+		xzr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzr), nil
+	case "tar.zst", "tzst":
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
+// seekableStream returns the tar stream of the archive read from r with
+// random access, or nil when r or the archive does not support it.
+func seekableStream(r io.Reader, format string) (io.ReadSeekCloser, error) {
+	ra, ok := r.(interface {
+		io.ReaderAt
+		io.ReadSeeker
+	})
+	if !ok {
+		return nil, nil
+	}
+	if format == "tar" {
+		return nopSeekCloser{ra}, nil
+	}
+	var open func(io.ReaderAt, int64) (io.ReadSeekCloser, error)
+	var errNotSeekable error
+	switch format {
+	case "tar.gz", "tgz":
+		open, errNotSeekable = targz.OpenSeekable, targz.ErrNotSeekable
+	case "tar.zst", "tzst":
+		open, errNotSeekable = tarzst.OpenSeekable, tarzst.ErrNotSeekable
+	default:
+		return nil, nil
+	}
+	start, err := ra.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	end, err := ra.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ra.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	rs, err := open(io.NewSectionReader(ra, start, end-start), end-start)
+	if errors.Is(err, errNotSeekable) {
+		return nil, nil
+	}
+	return rs, err
+}
+
+type nopSeekCloser struct{ io.ReadSeeker }
+
+func (nopSeekCloser) Close() error { return nil }
+
+// readerAt returns r as an io.ReaderAt along with its size, reading it into
+// memory when it does not support random access.
+func readerAt(r io.Reader) (io.ReaderAt, int64, error) {
+	if ra, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		size, err := ra.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, 0, err
+		}
+		return ra, size, nil
+	}
+	bts, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(bts), int64(len(bts)), nil
+}
+
+// entryType returns the type of entries with the given mode.
+func entryType(mode fs.FileMode) EntryType {
+	switch {
+	case mode.IsRegular():
+		return TypeFile
+	case mode.IsDir():
+		return TypeDir
+	case mode&fs.ModeSymlink != 0:
+		return TypeSymlink
+	case mode&fs.ModeCharDevice != 0:
+		return TypeChar
+	case mode&fs.ModeDevice != 0:
+		return TypeBlock
+	case mode&fs.ModeNamedPipe != 0:
+		return TypeFifo
+	default:
+		return TypeOther
+	}
+}
+
+type tarReader struct {
+	tr *tar.Reader
+	rc io.ReadCloser
+}
+
+func (r *tarReader) Next() (*Header, error) {
+	for {
+		h, err := r.tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		// global headers hold archive metadata, see ReadMetadata.
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		return tarHeader(h), nil
+	}
+}
+
+func (r *tarReader) Read(p []byte) (int, error) { return r.tr.Read(p) }
+func (r *tarReader) Close() error               { return r.rc.Close() }
+
+// tarHeader converts the tar header h.
+func tarHeader(h *tar.Header) *Header {
+	header := &Header{
+		Name:     strings.TrimSuffix(h.Name, "/"),
+		Size:     h.Size,
+		Mode:     h.FileInfo().Mode(),
+		ModTime:  h.ModTime,
+		Linkname: h.Linkname,
+		UID:      h.Uid,
+		GID:      h.Gid,
+		Uname:    h.Uname,
+		Gname:    h.Gname,
+	}
+	header.Type = entryType(header.Mode)
+	if h.Typeflag == tar.TypeLink {
+		header.Type = TypeHardlink
+	}
+	for k, v := range h.PAXRecords {
+		if name, ok := strings.CutPrefix(k, "SCHILY.xattr."); ok {
+			if header.Xattrs == nil {
+				header.Xattrs = map[string]string{}
+			}
+			header.Xattrs[name] = v
+		}
+	}
+	return header
+}
+
+type zipReader struct {
+	files []*zip.File
+	i     int
+	rc    io.ReadCloser
+}
+
+func (r *zipReader) Next() (*Header, error) {
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+	if r.i >= len(r.files) {
+		return nil, io.EOF
+	}
+	f := r.files[r.i]
+	r.i++
+	header := zipHeader(f)
+	if f.Mode().IsDir() {
+		return header, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Name, err)
+	}
+	if header.Type == TypeSymlink {
+		// the content of symlink entries is their target.
+		defer rc.Close()
+		link, err := io.ReadAll(rc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		header.Linkname = string(link)
+		header.Size = 0
+		return header, nil
+	}
+	r.rc = rc
+	return header, nil
+}
+
+func (r *zipReader) Read(p []byte) (int, error) {
+	if r.rc == nil {
+		return 0, io.EOF
+	}
+	return r.rc.Read(p)
+}
+
+func (r *zipReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
+
+// zipHeader converts the header of the zip entry f.
+func zipHeader(f *zip.File) *Header {
+	header := &Header{
+		Name:    strings.TrimSuffix(f.Name, "/"),
+		Size:    int64(f.UncompressedSize64),
+		Mode:    f.Mode(),
+		ModTime: f.Modified,
+	}
+	header.Type = entryType(header.Mode)
+	if header.Type == TypeDir {
+		header.Size = 0
+	}
+	header.UID, header.GID, _ = azip.Owner(f)
+	return header
+}
+
+type gzReader struct {
+	gzr  *pgzip.Reader
+	next bool
+}
+
+func (r *gzReader) Next() (*Header, error) {
+	if r.next {
+		return nil, io.EOF
+	}
+	r.next = true
+	return &Header{
+		Name:    r.gzr.Name,
+		Type:    TypeFile,
+		Size:    -1,
+		ModTime: r.gzr.ModTime,
+	}, nil
+}
+
+func (r *gzReader) Read(p []byte) (int, error) {
+	if !r.next {
+		return 0, io.EOF
+	}
+	return r.gzr.Read(p)
+}
+
+func (r *gzReader) Close() error { return r.gzr.Close() }
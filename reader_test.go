@@ -0,0 +1,162 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/kumose-go/archive/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	uid, gid := 1234, 5678
+	fsys := fstest.MapFS{
+		"bin":       {Mode: fs.ModeDir | 0o755, ModTime: mtime},
+		"bin/tool":  {Data: []byte("#!/bin/sh\n"), Mode: 0o755, ModTime: mtime},
+		"bin/alias": {Data: []byte("tool"), Mode: fs.ModeSymlink | 0o777, ModTime: mtime},
+	}
+
+	for _, format := range []string{"tar", "tar.gz", "tar.xz", "tar.zst", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "archive"))
+			require.NoError(t, err)
+			defer f.Close()
+			archive, err := New(f, format)
+			require.NoError(t, err)
+			require.NoError(t, AddTree(archive, fsys, config.File{
+				Source:      "bin",
+				Destination: "bin",
+				Info:        config.FileInfo{UID: &uid, GID: &gid},
+			}))
+			require.NoError(t, AddBytes(archive, config.File{
+				Destination: "VERSION",
+				Info:        config.FileInfo{ParsedMTime: mtime, Mode: 0o600},
+			}, []byte("1.2.3")))
+			require.NoError(t, archive.Close())
+			require.NoError(t, f.Close())
+
+			bts, err := os.ReadFile(f.Name())
+			require.NoError(t, err)
+			// zip archives are read into memory when r has no random access.
+			r, err := Open(io.MultiReader(bytes.NewReader(bts)), format)
+			require.NoError(t, err)
+			defer r.Close()
+
+			headers := map[string]*Header{}
+			contents := map[string]string{}
+			var order []string
+			for {
+				h, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				content, err := io.ReadAll(r)
+				require.NoError(t, err)
+				headers[h.Name] = h
+				contents[h.Name] = string(content)
+				order = append(order, h.Name)
+			}
+			require.Equal(t, []string{"bin", "bin/alias", "bin/tool", "VERSION"}, order)
+
+			require.Equal(t, TypeDir, headers["bin"].Type)
+			require.True(t, headers["bin"].Mode.IsDir())
+
+			tool := headers["bin/tool"]
+			require.Equal(t, TypeFile, tool.Type)
+			require.Equal(t, int64(10), tool.Size)
+			require.Equal(t, fs.FileMode(0o755), tool.Mode)
+			require.Equal(t, mtime, tool.ModTime.UTC())
+			require.Equal(t, uid, tool.UID)
+			require.Equal(t, gid, tool.GID)
+			require.Equal(t, "#!/bin/sh\n", contents["bin/tool"])
+
+			alias := headers["bin/alias"]
+			require.Equal(t, TypeSymlink, alias.Type)
+			require.Equal(t, "tool", alias.Linkname)
+			require.Empty(t, contents["bin/alias"])
+
+			version := headers["VERSION"]
+			require.Equal(t, fs.FileMode(0o600), version.Mode)
+			require.Equal(t, "1.2.3", contents["VERSION"])
+		})
+	}
+}
+
+func TestOpenTarHardlinkAndXattrs(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:       "a.txt",
+		Typeflag:   tar.TypeReg,
+		Size:       1,
+		Mode:       0o644,
+		PAXRecords: map[string]string{"SCHILY.xattr.user.origin": "ci"},
+	}))
+	_, err := tw.Write([]byte("a"))
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "b.txt", Typeflag: tar.TypeLink, Linkname: "a.txt"}))
+	require.NoError(t, tw.Close())
+
+	r, err := Open(&buf, "tar")
+	require.NoError(t, err)
+	defer r.Close()
+	h, err := r.Next()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"user.origin": "ci"}, h.Xattrs)
+	h, err = r.Next()
+	require.NoError(t, err)
+	require.Equal(t, TypeHardlink, h.Type)
+	require.Equal(t, "a.txt", h.Linkname)
+	_, err = r.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestOpenGz(t *testing.T) {
+	var buf bytes.Buffer
+	archive, err := New(&buf, "gz")
+	require.NoError(t, err)
+	require.NoError(t, AddBytes(archive, config.File{Destination: "app.log"}, []byte("log line\n")))
+	require.NoError(t, archive.Close())
+
+	r, err := Open(&buf, "gz")
+	require.NoError(t, err)
+	defer r.Close()
+	h, err := r.Next()
+	require.NoError(t, err)
+	require.Equal(t, "app.log", h.Name)
+	require.Equal(t, int64(-1), h.Size)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "log line\n", string(content))
+	_, err = r.Next()
+	require.ErrorIs(t, err, io.EOF)
+
+	_, err = Open(&buf, "rar")
+	require.ErrorContains(t, err, "unsupported archive format: rar")
+}
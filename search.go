@@ -0,0 +1,167 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/kumose-go/archive/config"
+)
+
+// NestedSeparator separates the name of a nested archive from the names of
+// its entries in search matches, as in "logs.tar.gz!/app/app.log".
+const NestedSeparator = "!/"
+
+// maxNesting bounds the depth of the nested archives searched.
+const maxNesting = 8
+
+// binarySize is the amount of content looked at for NUL bytes to tell
+// binary entries.
+const binarySize = 8000
+
+// Match is a line matching a search.
+type Match struct {
+	Name string // entry name, prefixed by the ones of its nested archives
+	Line int    // line number, from 1
+	Text string // line, without its line ending
+}
+
+// Search returns the lines of the regular files of the source archive,
+// read according to format or Auto, matching re. Entries holding archives
+// of a supported format, recognized by their content, are searched in turn;
+// nested zip archives are read into memory. Entries are read in archive
+// order and lines in file order.
+func Search(src, format string, re *regexp.Regexp, opts config.SearchOptions) ([]Match, error) {
+	if _, err := path.Match(opts.Name, ""); err != nil {
+		return nil, fmt.Errorf("invalid name pattern %q: %w", opts.Name, err)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if format == Auto {
+		if format, err = detectFile(f); err != nil {
+			return nil, err
+		}
+	}
+	r, err := Open(f, format)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	s := searcher{re: re, opts: opts}
+	if err := s.search(r, "", filepath.Base(src), 0); err != nil {
+		return nil, err
+	}
+	return s.matches, nil
+}
+
+type searcher struct {
+	re      *regexp.Regexp
+	opts    config.SearchOptions
+	matches []Match
+}
+
+// search searches the entries of r, whose names are prefixed by prefix.
+// archive is the name of the archive read, naming gz content without one.
+func (s *searcher) search(r Reader, prefix, archive string, depth int) error {
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Type != TypeFile {
+			continue
+		}
+		if h.Name == "" {
+			h.Name = strings.TrimSuffix(path.Base(archive), ".gz")
+		}
+		name := prefix + h.Name
+
+		br := bufio.NewReaderSize(r, headSize)
+		head, err := br.Peek(headSize)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if format, err := Detect(head); err == nil && depth < maxNesting {
+			nested, err := Open(br, format)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			err = s.search(nested, name+NestedSeparator, h.Name, depth+1)
+			nested.Close()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !s.matchName(h.Name) {
+			continue
+		}
+		if !s.opts.Binary && bytes.IndexByte(head[:min(len(head), binarySize)], 0) >= 0 {
+			continue
+		}
+		if err := s.searchLines(br, name); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+}
+
+// matchName reports whether the entry name is searched.
+func (s *searcher) matchName(name string) bool {
+	if s.opts.Name == "" {
+		return true
+	}
+	if !strings.Contains(s.opts.Name, "/") {
+		name = path.Base(name)
+	}
+	ok, _ := path.Match(s.opts.Name, name)
+	return ok
+}
+
+// searchLines records the lines read from r matching the search.
+func (s *searcher) searchLines(r *bufio.Reader, name string) error {
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			if s.re.Match(line) {
+				s.matches = append(s.matches, Match{Name: name, Line: n, Text: string(line)})
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
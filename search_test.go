@@ -0,0 +1,82 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/kumose-go/archive/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearch(t *testing.T) {
+	// a zip holding a gz file without name, nested in a tar.gz.
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, err := gw.Write([]byte("trace: start\ntrace: error in worker 3\n"))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	var zip bytes.Buffer
+	inner, err := New(&zip, "zip")
+	require.NoError(t, err)
+	require.NoError(t, AddBytes(inner, config.File{Destination: "inner/debug.log"}, []byte("debug: ok\r\ndebug: error 42\r\n")))
+	require.NoError(t, AddBytes(inner, config.File{Destination: "inner/trace.txt.gz"}, gz.Bytes()))
+	require.NoError(t, inner.Close())
+
+	src := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	f, err := os.Create(src)
+	require.NoError(t, err)
+	defer f.Close()
+	outer, err := New(f, "tar.gz")
+	require.NoError(t, err)
+	require.NoError(t, AddBytes(outer, config.File{Destination: "logs/app.log"}, []byte("info: started\nerror: disk full\ninfo: stopped\nerror: no more space")))
+	require.NoError(t, AddBytes(outer, config.File{Destination: "bin/tool"}, []byte("\x7fELF\x00\x00error")))
+	require.NoError(t, AddBytes(outer, config.File{Destination: "notes.txt"}, []byte("no error here\n")))
+	require.NoError(t, AddBytes(outer, config.File{Destination: "nested/logs.zip"}, zip.Bytes()))
+	require.NoError(t, outer.Close())
+	require.NoError(t, f.Close())
+
+	re := regexp.MustCompile(`error`)
+	matches, err := Search(src, Auto, re, config.SearchOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []Match{
+		{Name: "logs/app.log", Line: 2, Text: "error: disk full"},
+		{Name: "logs/app.log", Line: 4, Text: "error: no more space"},
+		{Name: "notes.txt", Line: 1, Text: "no error here"},
+		{Name: "nested/logs.zip!/inner/debug.log", Line: 2, Text: "debug: error 42"},
+		{Name: "nested/logs.zip!/inner/trace.txt.gz!/trace.txt", Line: 2, Text: "trace: error in worker 3"},
+	}, matches)
+
+	matches, err = Search(src, "tar.gz", re, config.SearchOptions{Name: "*.log"})
+	require.NoError(t, err)
+	require.Len(t, matches, 3)
+	require.Equal(t, "nested/logs.zip!/inner/debug.log", matches[2].Name)
+
+	matches, err = Search(src, "tar.gz", re, config.SearchOptions{Name: "bin/*", Binary: true})
+	require.NoError(t, err)
+	require.Equal(t, []Match{{Name: "bin/tool", Line: 1, Text: "\x7fELF\x00\x00error"}}, matches)
+
+	_, err = Search(src, "tar.gz", re, config.SearchOptions{Name: "["})
+	require.ErrorContains(t, err, "invalid name pattern")
+}
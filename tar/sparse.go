@@ -0,0 +1,253 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kumose-go/archive/internal/checksum"
+	"github.com/kumose-go/archive/internal/osutil"
+	"github.com/kumose-go/archive/internal/tarutil"
+)
+
+// PAX records of the GNU 1.0 sparse format. archive/tar can read this format
+// but drops these records when writing, so sparse entries are encoded here.
+const (
+	paxGNUSparseMajor    = "GNU.sparse.major"
+	paxGNUSparseMinor    = "GNU.sparse.minor"
+	paxGNUSparseName     = "GNU.sparse.name"
+	paxGNUSparseRealSize = "GNU.sparse.realsize"
+)
+
+// sparseRegions returns the data regions of file when it has holes worth
+// skipping.
+func (a Archive) sparseRegions(file *os.File, header *tar.Header) ([]osutil.Region, bool) {
+	size := header.Size
+	// sparse entries are written with PAX records.
+	if a.opts.DisableSparse || header.Format&(tar.FormatUSTAR|tar.FormatGNU) != 0 || size < tarutil.BlockSize {
+		return nil, false
+	}
+	regions := osutil.DataRegions(file, size)
+	if regions == nil {
+		return nil, false
+	}
+	var data int64
+	for _, r := range regions {
+		data += r.Length
+	}
+	if data == size {
+		return nil, false
+	}
+	if n := len(regions); n == 0 || regions[n-1].Offset+regions[n-1].Length < size {
+		// record the trailing hole, so readers ignoring the real size still
+		// restore the file length.
+		regions = append(regions, osutil.Region{Offset: size, Length: 0})
+	}
+	return regions, true
+}
+
+// writeSparse writes header and the data regions of file as a PAX 1.0
+// sparse entry, hashing the content of file with sum.
+func (a Archive) writeSparse(header *tar.Header, file *os.File, regions []osutil.Region, sum *checksum.Hasher) error {
+	var sparseMap bytes.Buffer
+	fmt.Fprintf(&sparseMap, "%d\n", len(regions))
+	var data int64
+	for _, r := range regions {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", r.Offset, r.Length)
+		data += r.Length
+	}
+	sparseMap.Write(make([]byte, tarutil.Padding(int64(sparseMap.Len()))))
+
+	dir, base := path.Split(header.Name)
+	main := *header
+	main.Name = path.Join(dir, "GNUSparseFile.0", base)
+	main.Size = int64(sparseMap.Len()) + data
+
+	records := map[string]string{
+		paxGNUSparseMajor:    "1",
+		paxGNUSparseMinor:    "0",
+		paxGNUSparseName:     header.Name,
+		paxGNUSparseRealSize: strconv.FormatInt(header.Size, 10),
+	}
+	if header.Format == tar.FormatPAX && header.ModTime.Unix() >= 0 && header.ModTime.Nanosecond() != 0 {
+		records["mtime"] = fmt.Sprintf("%d.%09d", header.ModTime.Unix(), header.ModTime.Nanosecond())
+	}
+	block, overflow := ustarBlock(&main, tar.TypeReg)
+	for k, v := range overflow {
+		records[k] = v
+	}
+	var pax bytes.Buffer
+	for _, k := range sortedKeys(records) {
+		pax.WriteString(paxRecord(k, records[k]))
+	}
+	paxHeader := tar.Header{
+		Name:    path.Join(dir, "PaxHeaders.0", base),
+		Size:    int64(pax.Len()),
+		Mode:    0o644,
+		ModTime: header.ModTime,
+	}
+	paxBlock, _ := ustarBlock(&paxHeader, tar.TypeXHeader)
+
+	// finish the previous entry before writing past the tar writer.
+	if err := a.tw.Flush(); err != nil {
+		return err
+	}
+	pax.Write(make([]byte, tarutil.Padding(int64(pax.Len()))))
+	for _, b := range [][]byte{paxBlock[:], pax.Bytes(), block[:], sparseMap.Bytes()} {
+		if _, err := a.w.Write(b); err != nil {
+			return err
+		}
+	}
+	// the content is hashed with its holes.
+	var end int64
+	for _, r := range regions {
+		sum.WriteZeros(r.Offset - end)
+		end = r.Offset + r.Length
+		if _, err := io.Copy(a.w, io.TeeReader(io.NewSectionReader(file, r.Offset, r.Length), sum)); err != nil {
+			return err
+		}
+	}
+	sum.WriteZeros(header.Size - end)
+	_, err := a.w.Write(make([]byte, tarutil.Padding(data)))
+	return err
+}
+
+// ustarBlock encodes the USTAR header block of h. The fields that do not
+// fit in it are returned as PAX records.
+func ustarBlock(h *tar.Header, typeflag byte) ([tarutil.BlockSize]byte, map[string]string) {
+	var b [tarutil.BlockSize]byte
+	overflow := map[string]string{}
+	str := func(field []byte, s, key string) {
+		if len(s) > len(field) || !isASCII(s) {
+			overflow[key] = s
+			s = ""
+		}
+		copy(field, s)
+	}
+	num := func(field []byte, n int64, key string) {
+		s := strconv.FormatInt(n, 8)
+		if n < 0 || len(s) > len(field)-1 {
+			overflow[key] = strconv.FormatInt(n, 10)
+			s = "0"
+		}
+		copy(field, strings.Repeat("0", len(field)-1-len(s))+s)
+	}
+	name, prefix := h.Name, ""
+	if len(name) > 100 {
+		if i := strings.LastIndexByte(name[:min(len(name), 156)], '/'); i > 0 && len(name)-i-1 <= 100 {
+			prefix, name = name[:i], name[i+1:]
+		}
+	}
+	str(b[0:100], name, "path")
+	str(b[345:500], prefix, "path")
+	if _, ok := overflow["path"]; ok {
+		overflow["path"] = h.Name
+	}
+	num(b[100:108], h.Mode&0o7777, "mode")
+	num(b[108:116], int64(h.Uid), "uid")
+	num(b[116:124], int64(h.Gid), "gid")
+	num(b[124:136], h.Size, "size")
+	num(b[136:148], h.ModTime.Unix(), "mtime")
+	b[156] = typeflag
+	copy(b[257:265], "ustar\x0000")
+	str(b[265:297], h.Uname, "uname")
+	str(b[297:329], h.Gname, "gname")
+
+	copy(b[148:156], "        ")
+	var sum int64
+	for _, c := range b {
+		sum += int64(c)
+	}
+	copy(b[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return b, overflow
+}
+
+// paxRecord formats a PAX record, prefixed with its own length.
+func paxRecord(k, v string) string {
+	const padding = 3 // extra padding for ' ', '=', and '\n'
+	size := len(k) + len(v) + padding
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	if len(record) != size {
+		// the length grew a digit once accounted for.
+		size = len(record)
+		record = strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	}
+	return record
+}
+
+func isASCII(s string) bool {
+	for _, c := range []byte(s) {
+		if c >= 0x80 || c == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// sparseWriter writes a file leaving holes in place of its blocks of zeros.
+type sparseWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *sparseWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk := p[:min(len(p), 4096)]
+		if !isZero(chunk) {
+			if _, err := w.f.WriteAt(chunk, w.off); err != nil {
+				return n - len(p), err
+			}
+		}
+		w.off += int64(len(chunk))
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Close sets the final file size, as trailing holes are never written.
+func (w *sparseWriter) Close() error {
+	return w.f.Truncate(w.off)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+//go:build linux
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/testlib"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarSparse(t *testing.T) {
+	const size = 4 << 20
+	folder := t.TempDir()
+	sparse, err := os.Create(filepath.Join(folder, "disk.img"))
+	require.NoError(t, err)
+	require.NoError(t, sparse.Truncate(size))
+	_, err = sparse.WriteAt([]byte("hello"), 1<<20)
+	require.NoError(t, err)
+	_, err = sparse.WriteAt([]byte("world"), 3<<20)
+	require.NoError(t, err)
+	require.NoError(t, sparse.Close())
+	if !hasHoles(t, sparse.Name()) {
+		t.Skip("the temporary directory does not support sparse files")
+	}
+	expected, err := os.ReadFile(sparse.Name())
+	require.NoError(t, err)
+
+	for name, opts := range map[string]config.CreateOptions{
+		"sparse": {},
+		"dense":  {DisableSparse: true},
+		"pax":    {TarFormat: "pax"},
+		"ustar":  {TarFormat: "ustar"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "test.tar"))
+			require.NoError(t, err)
+			defer f.Close()
+			opts.Checksums = []string{"sha256"}
+			archive := NewWithOptions(f, opts)
+			defer archive.Close()
+
+			require.NoError(t, archive.Add(config.File{
+				Source:      "../testdata/foo.txt",
+				Destination: "foo.txt",
+			}))
+			require.NoError(t, archive.Add(config.File{
+				Source:      sparse.Name(),
+				Destination: "images/disk.img",
+			}))
+			require.NoError(t, archive.Add(config.File{
+				Source:      "../testdata/regular.txt",
+				Destination: "regular.txt",
+			}))
+			require.NoError(t, archive.Close())
+			require.NoError(t, f.Close())
+
+			// holes are hashed as zeros.
+			sum := sha256.Sum256(expected)
+			checksums := archive.Checksums()
+			require.Len(t, checksums, 3)
+			require.Equal(t, "images/disk.img", checksums[1].Name)
+			require.Equal(t, hex.EncodeToString(sum[:]), checksums[1].Digests["sha256"])
+
+			dense := opts.DisableSparse || opts.TarFormat == "ustar"
+			info, err := os.Stat(f.Name())
+			require.NoError(t, err)
+			if dense {
+				require.Greater(t, info.Size(), int64(size))
+			} else {
+				require.Less(t, info.Size(), int64(64<<10))
+			}
+
+			require.Equal(t, []string{"foo.txt", "images/disk.img", "regular.txt"}, testlib.LsArchive(t, f.Name(), "tar"))
+			require.True(t, bytes.Equal(expected, readEntry(t, f.Name(), "images/disk.img")))
+			require.Equal(t, "regular file\n", string(readEntry(t, f.Name(), "regular.txt")))
+
+			dest := t.TempDir()
+			require.NoError(t, ExtractTar(f.Name(), dest, config.ExtractOptions{}))
+			bts, err := os.ReadFile(filepath.Join(dest, "images", "disk.img"))
+			require.NoError(t, err)
+			require.True(t, bytes.Equal(expected, bts))
+			if !dense {
+				require.True(t, hasHoles(t, filepath.Join(dest, "images", "disk.img")))
+			}
+		})
+	}
+}
+
+func hasHoles(tb testing.TB, name string) bool {
+	tb.Helper()
+	info, err := os.Stat(name)
+	require.NoError(tb, err)
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Blocks*512 < info.Size()
+}
+
+func readEntry(tb testing.TB, name, entry string) []byte {
+	tb.Helper()
+	f, err := os.Open(name)
+	require.NoError(tb, err)
+	defer f.Close()
+	r := tar.NewReader(f)
+	for {
+		next, err := r.Next()
+		require.NoError(tb, err)
+		if next.Name == entry {
+			bts, err := io.ReadAll(r)
+			require.NoError(tb, err)
+			return bts
+		}
+	}
+}
@@ -19,31 +19,105 @@ package tar
 
 import (
 	"archive/tar"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/internal/checksum"
+	"github.com/kumose-go/archive/internal/names"
+	"github.com/kumose-go/archive/internal/osutil"
+	"github.com/kumose-go/archive/internal/sized"
 )
 
+// globalHeaderName is the name GNU tar gives to PAX global headers.
+const globalHeaderName = "pax_global_header"
+
 // Archive as tar.
 type Archive struct {
+	w     io.Writer
 	tw    *tar.Writer
 	files map[string]bool
+	links map[osutil.FileID]string
+	opts  config.CreateOptions
+	sums  *checksum.Recorder
+	err   error
+	mu    *sync.Mutex
 }
 
 // New tar archive.
 func New(target io.Writer) Archive {
-	return Archive{
+	return NewWithOptions(target, config.CreateOptions{})
+}
+
+// NewWithOptions creates a tar archive written according to opts.
+// Records of opts.Metadata are written in a PAX global header. The archive is
+// safe for concurrent use.
+func NewWithOptions(target io.Writer, opts config.CreateOptions) Archive {
+	a := Archive{
+		w:     target,
 		tw:    tar.NewWriter(target),
 		files: map[string]bool{},
+		links: map[osutil.FileID]string{},
+		opts:  opts,
+		sums:  checksum.NewRecorder(opts.Checksums),
+		mu:    &sync.Mutex{},
+	}
+	if len(opts.Metadata.Records) > 0 {
+		// reported by the next call, as there is no error to return here.
+		a.err = a.writeGlobalHeader(opts.Metadata.Records)
+	}
+	return a
+}
+
+// writeGlobalHeader writes the PAX global header holding records.
+func (a Archive) writeGlobalHeader(records map[string]string) error {
+	if a.opts.TarFormat != "" && a.opts.TarFormat != "pax" {
+		return fmt.Errorf("metadata records need the pax tar format, not %s", a.opts.TarFormat)
+	}
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		Name:       globalHeaderName,
+		PAXRecords: records,
+	})
+	if err != nil {
+		return fmt.Errorf("metadata: %w", err)
 	}
+	return nil
+}
+
+// ReadMetadata reads the records of the PAX global header at the start of
+// the tar stream r, if any.
+func ReadMetadata(r io.Reader) (config.Metadata, error) {
+	header, err := tar.NewReader(r).Next()
+	if err == io.EOF {
+		return config.Metadata{}, nil
+	}
+	if err != nil {
+		return config.Metadata{}, err
+	}
+	if header.Typeflag != tar.TypeXGlobalHeader {
+		return config.Metadata{}, nil
+	}
+	return config.Metadata{Records: header.PAXRecords}, nil
 }
 
 // Copy creates a new tar with the contents of the given tar.
 func Copy(source io.Reader, target io.Writer) (Archive, error) {
-	w := New(target)
+	return CopyWithOptions(source, target, config.CreateOptions{})
+}
+
+// CopyWithOptions creates a new tar with the contents of the given tar,
+// written according to opts. The duplicate policy applies to the entries
+// of the given tar too. Records of opts.Metadata replace the PAX global
+// header of the given tar, which is copied otherwise.
+func CopyWithOptions(source io.Reader, target io.Writer, opts config.CreateOptions) (Archive, error) {
+	w := NewWithOptions(target, opts)
 	r := tar.NewReader(source)
 	for {
 		header, err := r.Next()
@@ -53,71 +127,270 @@ func Copy(source io.Reader, target io.Writer) (Archive, error) {
 		if err != nil {
 			return Archive{}, err
 		}
-		w.files[header.Name] = true
+		if header.Typeflag == tar.TypeXGlobalHeader && len(opts.Metadata.Records) > 0 {
+			// NewWithOptions wrote the requested records already.
+			continue
+		}
+		// global headers and root entries, such as "./", are not files.
+		name := names.Normalize(header.Name)
+		sum, err := w.sums.Hasher()
+		if err != nil {
+			return w, err
+		}
+		if header.Typeflag != tar.TypeXGlobalHeader && name != "" {
+			ok, err := names.Claim(w.files, name, opts.Duplicates)
+			if err != nil {
+				return w, err
+			}
+			if !ok {
+				continue
+			}
+		}
 		if err := w.tw.WriteHeader(header); err != nil {
 			return w, err
 		}
-		if _, err := io.Copy(w.tw, r); err != nil {
+		if _, err := io.Copy(w.tw, io.TeeReader(r, sum)); err != nil {
 			return w, err
 		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			w.sums.Record(name, sum)
+		case tar.TypeLink:
+			w.sums.Link(name, names.Normalize(header.Linkname))
+		}
 	}
 	return w, nil
 }
 
-// Close all closeables.
+// Close all closeables, once the checksum manifest is written, if any.
 func (a Archive) Close() error {
-	return a.tw.Close()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var manifestErr error
+	if a.err == nil && a.opts.ChecksumManifest != "" {
+		manifestErr = a.writeManifest()
+	}
+	// the end of the archive is written even when the manifest is not.
+	return errors.Join(manifestErr, a.tw.Close(), a.err)
+}
+
+// writeManifest writes the entry listing the digests of the entries.
+func (a Archive) writeManifest() error {
+	name := names.Normalize(a.opts.ChecksumManifest)
+	if ok, err := names.Claim(a.files, name, a.opts.Duplicates); !ok {
+		return err
+	}
+	manifest := a.sums.Manifest()
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(manifest)),
+		Mode:     0o644,
+		ModTime:  time.Now(),
+	}
+	if err := a.setFormat(header); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if _, err := a.tw.Write(manifest); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// Checksums returns the digests of the regular files added, sorted by name,
+// computed with the algorithms set in the options.
+func (a Archive) Checksums() []config.Checksum {
+	return a.sums.Checksums()
 }
 
 // Add file to the archive.
 func (a Archive) Add(f config.File) error {
-	if _, ok := a.files[f.Destination]; ok {
-		return &fs.PathError{Err: fs.ErrExist, Path: f.Destination, Op: "add"}
+	return a.AddFS(osutil.FS{}, f)
+}
+
+// AddFS adds the file f.Source of fsys to the archive. Symlinks are archived
+// as such when fsys implements fs.ReadLinkFS, unless f.FollowSymlinks is set.
+func (a Archive) AddFS(fsys fs.FS, f config.File) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return a.err
+	}
+	name := names.Normalize(f.Destination)
+	if ok, err := names.Claim(a.files, name, a.opts.Duplicates); !ok {
+		return err
 	}
-	a.files[f.Destination] = true
-	info, err := os.Lstat(f.Source) // #nosec
+	info, link, err := osutil.Lstat(fsys, f.Source, f.FollowSymlinks, a.opts.RejectDanglingSymlinks)
 	if err != nil {
 		return fmt.Errorf("%s: %w", f.Source, err)
 	}
-	var link string
-	if info.Mode()&os.ModeSymlink != 0 {
-		link, err = os.Readlink(f.Source) // #nosec
-		if err != nil {
-			return fmt.Errorf("%s: %w", f.Source, err)
-		}
+	sum, err := a.sums.Hasher()
+	if err != nil {
+		return err
 	}
 	header, err := tar.FileInfoHeader(info, link)
 	if err != nil {
 		return fmt.Errorf("%s: %w", f.Source, err)
 	}
-	header.Name = f.Destination
-	if !f.Info.ParsedMTime.IsZero() {
-		header.ModTime = f.Info.ParsedMTime
+	header.Name = name
+	applyInfo(header, f.Info)
+	if err := a.setFormat(header); err != nil {
+		return fmt.Errorf("%s: %w", f.Source, err)
 	}
-	if f.Info.Mode != 0 {
-		header.Mode = int64(f.Info.Mode)
+	if first, ok := a.hardlink(info, name); ok {
+		header.Typeflag = tar.TypeLink
+		header.Linkname = first
+		header.Size = 0
+		a.sums.Link(name, first)
 	}
-	if f.Info.Owner != "" {
-		header.Uid = 0
-		header.Uname = f.Info.Owner
+	if header.Typeflag != tar.TypeReg {
+		if err = a.tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("%s: %w", f.Source, err)
+		}
+		return nil
 	}
-	if f.Info.Group != "" {
-		header.Gid = 0
-		header.Gname = f.Info.Group
+	file, err := fsys.Open(f.Source)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.Source, err)
+	}
+	defer file.Close()
+	if osFile, ok := file.(*os.File); ok {
+		if regions, ok := a.sparseRegions(osFile, header); ok {
+			if err := a.writeSparse(header, osFile, regions, sum); err != nil {
+				return fmt.Errorf("%s: %w", f.Source, err)
+			}
+			a.sums.Record(name, sum)
+			return nil
+		}
 	}
 	if err = a.tw.WriteHeader(header); err != nil {
 		return fmt.Errorf("%s: %w", f.Source, err)
 	}
-	if info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
-		return nil
+	if _, err := io.Copy(a.tw, io.TeeReader(file, sum)); err != nil {
+		return fmt.Errorf("%s: %w", f.Source, err)
 	}
-	file, err := os.Open(f.Source) // #nosec
+	a.sums.Record(name, sum)
+	return nil
+}
+
+// AddReader adds a regular file whose content is read from r.
+// The entry is named after f.Destination and takes its metadata from f.Info;
+// f.Source is ignored. It is an error for r to yield fewer or more than size
+// bytes; a negative size has r read into memory first.
+func (a Archive) AddReader(f config.File, size int64, r io.Reader) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return a.err
+	}
+	name := names.Normalize(f.Destination)
+	if ok, err := names.Claim(a.files, name, a.opts.Duplicates); !ok {
+		return err
+	}
+	sum, err := a.sums.Hasher()
 	if err != nil {
-		return fmt.Errorf("%s: %w", f.Source, err)
+		return err
 	}
-	defer file.Close()
-	if _, err := io.Copy(a.tw, file); err != nil {
-		return fmt.Errorf("%s: %w", f.Source, err)
+	if size < 0 {
+		bts, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Destination, err)
+		}
+		size = int64(len(bts))
+		r = bytes.NewReader(bts)
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  time.Now(),
+	}
+	applyInfo(header, f.Info)
+	if err := a.setFormat(header); err != nil {
+		return fmt.Errorf("%s: %w", f.Destination, err)
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("%s: %w", f.Destination, err)
+	}
+	if _, err := io.Copy(a.tw, io.TeeReader(sized.Reader(r, size), sum)); err != nil {
+		return fmt.Errorf("%s: %w", f.Destination, err)
 	}
+	a.sums.Record(name, sum)
 	return nil
 }
+
+// AddBytes adds a regular file with the given content to the archive.
+func (a Archive) AddBytes(f config.File, content []byte) error {
+	return a.AddReader(f, int64(len(content)), bytes.NewReader(content))
+}
+
+// hardlink returns the destination under which a file sharing the inode of
+// info was first archived. Otherwise, the file is recorded as the first one
+// for its inode.
+func (a Archive) hardlink(info fs.FileInfo, destination string) (string, bool) {
+	if a.opts.DisableHardlinks || !info.Mode().IsRegular() {
+		return "", false
+	}
+	id, links, ok := osutil.Inode(info)
+	if !ok || links < 2 {
+		return "", false
+	}
+	if first, ok := a.links[id]; ok {
+		return first, true
+	}
+	a.links[id] = destination
+	return "", false
+}
+
+// setFormat pins the format of header to the one set in the options, so
+// archive/tar reports the fields that do not fit in it instead of switching
+// to another format.
+func (a Archive) setFormat(header *tar.Header) error {
+	switch a.opts.TarFormat {
+	case "":
+		return nil
+	case "ustar":
+		header.Format = tar.FormatUSTAR
+	case "pax":
+		header.Format = tar.FormatPAX
+	case "gnu":
+		header.Format = tar.FormatGNU
+	default:
+		return fmt.Errorf("invalid tar format: %s", a.opts.TarFormat)
+	}
+	// archive/tar only ignores these when the format is not set.
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	return nil
+}
+
+// applyInfo overrides the header metadata with the one set in info.
+func applyInfo(header *tar.Header, info config.FileInfo) {
+	if !info.ParsedMTime.IsZero() {
+		header.ModTime = info.ParsedMTime
+	}
+	if info.Mode != 0 {
+		header.Mode = int64(info.Mode)
+	}
+	if info.Owner != "" {
+		header.Uid = 0
+		header.Uname = info.Owner
+	}
+	if info.UID != nil {
+		header.Uid = *info.UID
+		// do not keep the name of the source file owner along a different id.
+		header.Uname = info.Owner
+	}
+	if info.Group != "" {
+		header.Gid = 0
+		header.Gname = info.Group
+	}
+	if info.GID != nil {
+		header.Gid = *info.GID
+		header.Gname = info.Group
+	}
+}
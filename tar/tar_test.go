@@ -19,14 +19,17 @@ package tar
 
 import (
 	"archive/tar"
+	"bytes"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/internal/sized"
 	"github.com/kumose-go/archive/testlib"
 	"github.com/stretchr/testify/require"
 )
@@ -191,6 +194,241 @@ func TestTarInvalidLink(t *testing.T) {
 	}))
 }
 
+func TestTarRejectDanglingSymlinks(t *testing.T) {
+	archive := NewWithOptions(io.Discard, config.CreateOptions{RejectDanglingSymlinks: true})
+	defer archive.Close()
+
+	err := archive.Add(config.File{
+		Source:      "../testdata/badlink.txt",
+		Destination: "badlink.txt",
+	})
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.ErrorContains(t, err, "dangling symlink")
+	require.NoError(t, archive.Add(config.File{
+		Source:      "../testdata/link.txt",
+		Destination: "link.txt",
+	}))
+}
+
+func TestTarFollowSymlinks(t *testing.T) {
+	var buf bytes.Buffer
+	archive := New(&buf)
+	require.NoError(t, archive.Add(config.File{
+		Source:         "../testdata/link.txt",
+		Destination:    "link.txt",
+		FollowSymlinks: true,
+	}))
+	require.Error(t, archive.Add(config.File{
+		Source:         "../testdata/badlink.txt",
+		Destination:    "badlink.txt",
+		FollowSymlinks: true,
+	}))
+	require.NoError(t, archive.Close())
+
+	r := tar.NewReader(&buf)
+	next, err := r.Next()
+	require.NoError(t, err)
+	require.Equal(t, "link.txt", next.Name)
+	require.Equal(t, byte(tar.TypeReg), next.Typeflag)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "regular file\n", string(content))
+}
+
+func TestTarFormat(t *testing.T) {
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 500, time.UTC)
+	longName := strings.Repeat("dir/", 40) + strings.Repeat("a", 120)
+	bigID := 1 << 22
+
+	t.Run("invalid", func(t *testing.T) {
+		archive := NewWithOptions(io.Discard, config.CreateOptions{TarFormat: "v7"})
+		defer archive.Close()
+		require.ErrorContains(t, archive.AddBytes(config.File{Destination: "a.txt"}, nil), "invalid tar format: v7")
+	})
+
+	t.Run("ustar", func(t *testing.T) {
+		archive := NewWithOptions(io.Discard, config.CreateOptions{TarFormat: "ustar"})
+		defer archive.Close()
+		require.NoError(t, archive.Add(config.File{
+			Source:      "../testdata/regular.txt",
+			Destination: "regular.txt",
+		}))
+		require.ErrorContains(t, archive.AddBytes(config.File{Destination: longName}, nil), "Name=")
+		require.ErrorContains(t, archive.AddBytes(config.File{
+			Destination: "big.txt",
+			Info:        config.FileInfo{UID: &bigID},
+		}, nil), "Uid=")
+		require.ErrorContains(t, archive.AddBytes(config.File{Destination: "été.txt"}, nil), "Name=")
+	})
+
+	for name, format := range map[string]tar.Format{
+		"gnu": tar.FormatGNU,
+		"pax": tar.FormatPAX,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			archive := NewWithOptions(&buf, config.CreateOptions{TarFormat: name})
+			require.NoError(t, archive.AddBytes(config.File{
+				Destination: longName,
+				Info:        config.FileInfo{UID: &bigID, ParsedMTime: mtime},
+			}, []byte("content")))
+			require.NoError(t, archive.Close())
+
+			next, err := tar.NewReader(&buf).Next()
+			require.NoError(t, err)
+			require.Equal(t, longName, next.Name)
+			require.Equal(t, bigID, next.Uid)
+			require.True(t, next.Format&format != 0)
+			if format == tar.FormatPAX {
+				require.Equal(t, mtime, next.ModTime.UTC())
+			} else {
+				require.Equal(t, mtime.Truncate(time.Second), next.ModTime.UTC())
+			}
+		})
+	}
+}
+
+func TestTarMetadata(t *testing.T) {
+	records := map[string]string{"KUMOSE.version": "1.2.3"}
+
+	var buf bytes.Buffer
+	archive := NewWithOptions(&buf, config.CreateOptions{
+		Metadata: config.Metadata{Records: records},
+	})
+	require.NoError(t, archive.AddBytes(config.File{Destination: "a.txt"}, []byte("a")))
+	require.NoError(t, archive.Close())
+	meta, err := ReadMetadata(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, records, meta.Records)
+
+	var copied bytes.Buffer
+	archive, err = Copy(bytes.NewReader(buf.Bytes()), &copied)
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+	meta, err = ReadMetadata(&copied)
+	require.NoError(t, err)
+	require.Equal(t, records, meta.Records)
+
+	// requested records replace the copied ones.
+	replaced := map[string]string{"KUMOSE.version": "2.0.0"}
+	copied.Reset()
+	archive, err = CopyWithOptions(bytes.NewReader(buf.Bytes()), &copied, config.CreateOptions{
+		Metadata: config.Metadata{Records: replaced},
+	})
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+	var globals []map[string]string
+	var entries []string
+	r := tar.NewReader(bytes.NewReader(copied.Bytes()))
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			globals = append(globals, h.PAXRecords)
+			continue
+		}
+		entries = append(entries, h.Name)
+	}
+	require.Equal(t, []map[string]string{replaced}, globals)
+	require.Equal(t, []string{"a.txt"}, entries)
+
+	meta, err = ReadMetadata(bytes.NewReader(nil))
+	require.NoError(t, err)
+	require.Empty(t, meta.Records)
+
+	archive = NewWithOptions(io.Discard, config.CreateOptions{
+		TarFormat: "ustar",
+		Metadata:  config.Metadata{Records: records},
+	})
+	require.ErrorContains(t, archive.AddBytes(config.File{Destination: "a.txt"}, nil), "need the pax tar format")
+	require.Error(t, archive.Close())
+}
+
+func TestTarNormalizeDestinations(t *testing.T) {
+	var buf bytes.Buffer
+	archive := New(&buf)
+	require.NoError(t, archive.AddBytes(config.File{Destination: "./a.txt"}, nil))
+	require.ErrorIs(t, archive.AddBytes(config.File{Destination: "a.txt"}, nil), fs.ErrExist)
+	require.ErrorIs(t, archive.AddBytes(config.File{Destination: "/a.txt"}, nil), fs.ErrExist)
+	require.NoError(t, archive.AddBytes(config.File{Destination: "dir//sub/../b.txt"}, nil))
+	require.ErrorIs(t, archive.AddBytes(config.File{Destination: "./"}, nil), fs.ErrInvalid)
+	require.NoError(t, archive.Add(config.File{Source: "../testdata/sub1", Destination: "/sub1/"}))
+	require.NoError(t, archive.Close())
+
+	var names []string
+	r := tar.NewReader(&buf)
+	for {
+		next, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, next.Name)
+	}
+	require.Equal(t, []string{"a.txt", "dir/b.txt", "sub1"}, names)
+}
+
+func TestTarDuplicates(t *testing.T) {
+	tmp := t.TempDir()
+	src, err := os.Create(filepath.Join(tmp, "src.tar"))
+	require.NoError(t, err)
+	defer src.Close()
+	archive := New(src)
+	require.NoError(t, archive.AddBytes(config.File{Destination: "dir/a.txt"}, []byte("first")))
+	require.NoError(t, archive.Close())
+
+	for policy, expected := range map[string]string{
+		"":        "",
+		"error":   "",
+		"keep":    "first",
+		"replace": "second",
+		"nope":    "",
+	} {
+		t.Run(policy, func(t *testing.T) {
+			opts := config.CreateOptions{Duplicates: policy}
+			_, err := src.Seek(0, io.SeekStart)
+			require.NoError(t, err)
+			target, err := os.Create(filepath.Join(t.TempDir(), "target.tar"))
+			require.NoError(t, err)
+			defer target.Close()
+			archive, err := CopyWithOptions(src, target, opts)
+			if policy == "nope" {
+				require.ErrorContains(t, err, "invalid duplicate policy: nope")
+				return
+			}
+			require.NoError(t, err)
+			err = archive.AddBytes(config.File{Destination: "./dir/a.txt"}, []byte("second"))
+			if expected == "" {
+				require.ErrorIs(t, err, fs.ErrExist)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, archive.Close())
+			require.NoError(t, target.Close())
+
+			dest := t.TempDir()
+			require.NoError(t, ExtractTar(target.Name(), dest, config.ExtractOptions{}))
+			content, err := os.ReadFile(filepath.Join(dest, "dir", "a.txt"))
+			require.NoError(t, err)
+			require.Equal(t, expected, string(content))
+
+			// duplicates of the copied archive follow the policy too.
+			target, err = os.Open(target.Name())
+			require.NoError(t, err)
+			defer target.Close()
+			_, err = CopyWithOptions(target, io.Discard, config.CreateOptions{})
+			if policy == "replace" {
+				require.ErrorIs(t, err, fs.ErrExist)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
 func TestCopying(t *testing.T) {
 	f1, err := os.Create(filepath.Join(t.TempDir(), "1.tar"))
 	require.NoError(t, err)
@@ -229,3 +467,372 @@ func TestCopying(t *testing.T) {
 	require.Equal(t, []string{"foo.txt", "ملف.txt"}, testlib.LsArchive(t, f1.Name(), "tar"))
 	require.Equal(t, []string{"foo.txt", "ملف.txt", "executable", "ملف.exe"}, testlib.LsArchive(t, f2.Name(), "tar"))
 }
+
+func TestTarAddReader(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.tar"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive := New(f)
+	defer archive.Close()
+
+	require.NoError(t, archive.AddReader(config.File{
+		Destination: "checksums.txt",
+		Info: config.FileInfo{
+			Mode:        0o600,
+			Owner:       "carlos",
+			ParsedMTime: now,
+		},
+	}, 5, strings.NewReader("hello")))
+	require.NoError(t, archive.AddReader(config.File{
+		Destination: "unknown-size.txt",
+	}, -1, strings.NewReader("some content")))
+	require.NoError(t, archive.AddBytes(config.File{
+		Destination: "version",
+	}, []byte("v1.0.0")))
+	require.ErrorIs(t, archive.AddBytes(config.File{
+		Destination: "version",
+	}, []byte("v2.0.0")), fs.ErrExist)
+
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	require.Equal(t, "hello", string(testlib.GetFileFromArchive(t, f.Name(), "tar", "checksums.txt")))
+	require.Equal(t, "some content", string(testlib.GetFileFromArchive(t, f.Name(), "tar", "unknown-size.txt")))
+	require.Equal(t, "v1.0.0", string(testlib.GetFileFromArchive(t, f.Name(), "tar", "version")))
+
+	f, err = os.Open(f.Name())
+	require.NoError(t, err)
+	defer f.Close()
+	r := tar.NewReader(f)
+	next, err := r.Next()
+	require.NoError(t, err)
+	require.Equal(t, "checksums.txt", next.Name)
+	require.Equal(t, now, next.ModTime)
+	require.Equal(t, fs.FileMode(0o600), next.FileInfo().Mode())
+	require.Equal(t, "carlos", next.Uname)
+	next, err = r.Next()
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0o644), next.FileInfo().Mode())
+}
+
+func TestTarAddReaderShort(t *testing.T) {
+	archive := New(io.Discard)
+	defer archive.Close()
+
+	require.ErrorIs(t, archive.AddReader(config.File{
+		Destination: "short.txt",
+	}, 10, strings.NewReader("short")), io.ErrUnexpectedEOF)
+}
+
+func TestTarAddReaderLong(t *testing.T) {
+	archive := New(io.Discard)
+	defer archive.Close()
+
+	require.ErrorIs(t, archive.AddReader(config.File{
+		Destination: "long.txt",
+	}, 4, strings.NewReader("too long")), sized.ErrTooLong)
+}
+
+func TestExtractTar(t *testing.T) {
+	now := time.Now().Add(-time.Hour).Truncate(time.Second)
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.tar"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive := New(f)
+	defer archive.Close()
+
+	for _, file := range []config.File{
+		{Source: "../testdata/sub1", Destination: "top"},
+		{Source: "../testdata/sub1/sub2", Destination: "top/sub2"},
+		{Source: "../testdata/sub1/sub2/subfoo.txt", Destination: "top/sub2/subfoo.txt"},
+		{Source: "../testdata/link.txt", Destination: "top/link.txt"},
+	} {
+		file.Info.ParsedMTime = now
+		require.NoError(t, archive.Add(file))
+	}
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	dest := t.TempDir()
+	opts := config.ExtractOptions{
+		StripTopDir:   true,
+		PreserveMTime: true,
+	}
+	require.NoError(t, ExtractTar(f.Name(), dest, opts))
+
+	bts, err := os.ReadFile(filepath.Join(dest, "sub2", "subfoo.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "sub\n", string(bts))
+	for _, name := range []string{"sub2", "sub2/subfoo.txt"} {
+		info, err := os.Stat(filepath.Join(dest, name))
+		require.NoError(t, err)
+		require.Equal(t, now, info.ModTime(), name)
+	}
+	if !testlib.IsWindows() {
+		link, err := os.Readlink(filepath.Join(dest, "link.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "regular.txt", link)
+	}
+
+	require.Error(t, ExtractTar(f.Name(), dest, opts))
+	opts.Overwrite = true
+	require.NoError(t, ExtractTar(f.Name(), dest, opts))
+}
+
+func TestExtractStripTopDir(t *testing.T) {
+	var buf bytes.Buffer
+	archive := New(&buf)
+	defer archive.Close()
+
+	require.NoError(t, archive.Add(config.File{
+		Source:      "../testdata/sub1",
+		Destination: "pkg-1.0",
+	}))
+	require.NoError(t, archive.AddBytes(config.File{Destination: "pkg-1.0/bin/tool"}, []byte("tool")))
+	require.NoError(t, archive.AddBytes(config.File{Destination: "README"}, []byte("readme")))
+	require.NoError(t, archive.Close())
+
+	dest := t.TempDir()
+	require.NoError(t, Extract(&buf, dest, config.ExtractOptions{StripTopDir: true}))
+
+	bts, err := os.ReadFile(filepath.Join(dest, "bin", "tool"))
+	require.NoError(t, err)
+	require.Equal(t, "tool", string(bts))
+	bts, err = os.ReadFile(filepath.Join(dest, "README"))
+	require.NoError(t, err)
+	require.Equal(t, "readme", string(bts))
+}
+
+func TestExtractHardlinkOutside(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(root, "x")
+	require.NoError(t, os.WriteFile(outside, []byte("outside"), 0o644))
+	dest := filepath.Join(root, "a", "dest")
+	require.NoError(t, os.MkdirAll(dest, 0o755))
+
+	for _, linkname := range []string{"../../x", outside} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeLink,
+			Name:     "evil",
+			Linkname: linkname,
+		}))
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     "evil",
+			Mode:     0o644,
+			Size:     4,
+		}))
+		_, err := tw.Write([]byte("evil"))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+
+		require.ErrorContains(t, Extract(&buf, dest, config.ExtractOptions{}), "points outside of the extracted tree")
+		bts, err := os.ReadFile(outside)
+		require.NoError(t, err)
+		require.Equal(t, "outside", string(bts))
+	}
+}
+
+func TestExtractReplaceLinks(t *testing.T) {
+	testlib.SkipIfWindows(t, "symlinks and hardlinks are not extracted on windows")
+	type entry struct {
+		header  tar.Header
+		content string
+	}
+	write := func(entries ...entry) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, e := range entries {
+			e.header.Mode = 0o644
+			e.header.Size = int64(len(e.content))
+			require.NoError(t, tw.WriteHeader(&e.header))
+			_, err := tw.Write([]byte(e.content))
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.Close())
+		return &buf
+	}
+
+	t.Run("symlink", func(t *testing.T) {
+		outside := filepath.Join(t.TempDir(), "outside")
+		require.NoError(t, os.WriteFile(outside, []byte("outside"), 0o644))
+		dest := t.TempDir()
+
+		require.NoError(t, Extract(write(
+			entry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "x", Linkname: outside}},
+			entry{header: tar.Header{Typeflag: tar.TypeReg, Name: "x"}, content: "replaced"},
+		), dest, config.ExtractOptions{}))
+
+		bts, err := os.ReadFile(outside)
+		require.NoError(t, err)
+		require.Equal(t, "outside", string(bts))
+		bts, err = os.ReadFile(filepath.Join(dest, "x"))
+		require.NoError(t, err)
+		require.Equal(t, "replaced", string(bts))
+	})
+
+	t.Run("hardlink", func(t *testing.T) {
+		dest := t.TempDir()
+
+		require.NoError(t, Extract(write(
+			entry{header: tar.Header{Typeflag: tar.TypeReg, Name: "a"}, content: "a"},
+			entry{header: tar.Header{Typeflag: tar.TypeLink, Name: "b", Linkname: "a"}},
+			entry{header: tar.Header{Typeflag: tar.TypeReg, Name: "b"}, content: "b"},
+		), dest, config.ExtractOptions{}))
+
+		bts, err := os.ReadFile(filepath.Join(dest, "a"))
+		require.NoError(t, err)
+		require.Equal(t, "a", string(bts))
+		bts, err = os.ReadFile(filepath.Join(dest, "b"))
+		require.NoError(t, err)
+		require.Equal(t, "b", string(bts))
+	})
+}
+
+func TestExtractHardlinkThroughSymlink(t *testing.T) {
+	testlib.SkipIfWindows(t, "symlinks and hardlinks are not extracted on windows")
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "x"), []byte("outside"), 0o644))
+	dest := t.TempDir()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "d", Linkname: outside}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "y", Linkname: "d/x"}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "y", Mode: 0o644, Size: 4}))
+	_, err := tw.Write([]byte("evil"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	require.ErrorContains(t, Extract(&buf, dest, config.ExtractOptions{}), "hardlink y")
+	bts, err := os.ReadFile(filepath.Join(outside, "x"))
+	require.NoError(t, err)
+	require.Equal(t, "outside", string(bts))
+	_, err = os.Lstat(filepath.Join(dest, "y"))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestTarFileInfoIDs(t *testing.T) {
+	uid, gid := 1000, 1001
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.tar"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive := New(f)
+	defer archive.Close()
+
+	require.NoError(t, archive.Add(config.File{
+		Source:      "../testdata/foo.txt",
+		Destination: "named.txt",
+		Info: config.FileInfo{
+			Owner: "carlos",
+			Group: "staff",
+			UID:   &uid,
+			GID:   &gid,
+		},
+	}))
+	require.NoError(t, archive.AddBytes(config.File{
+		Destination: "numeric.txt",
+		Info: config.FileInfo{
+			UID: &uid,
+			GID: &gid,
+		},
+	}, []byte("foo")))
+	require.NoError(t, archive.AddBytes(config.File{
+		Destination: "nameonly.txt",
+		Info: config.FileInfo{
+			Owner: "nobody",
+			Group: "nobody",
+		},
+	}, []byte("foo")))
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	f, err = os.Open(f.Name())
+	require.NoError(t, err)
+	defer f.Close()
+
+	r := tar.NewReader(f)
+	next, err := r.Next()
+	require.NoError(t, err)
+	require.Equal(t, "named.txt", next.Name)
+	require.Equal(t, uid, next.Uid)
+	require.Equal(t, "carlos", next.Uname)
+	require.Equal(t, gid, next.Gid)
+	require.Equal(t, "staff", next.Gname)
+
+	next, err = r.Next()
+	require.NoError(t, err)
+	require.Equal(t, "numeric.txt", next.Name)
+	require.Equal(t, uid, next.Uid)
+	require.Empty(t, next.Uname)
+	require.Equal(t, gid, next.Gid)
+	require.Empty(t, next.Gname)
+
+	// names are not looked up on the current system.
+	next, err = r.Next()
+	require.NoError(t, err)
+	require.Equal(t, "nameonly.txt", next.Name)
+	require.Equal(t, 0, next.Uid)
+	require.Equal(t, "nobody", next.Uname)
+	require.Equal(t, 0, next.Gid)
+	require.Equal(t, "nobody", next.Gname)
+}
+
+func TestTarHardlinks(t *testing.T) {
+	testlib.SkipIfWindows(t, "hardlinks are not detected on windows")
+	folder := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "a.txt"), []byte("content"), 0o644))
+	require.NoError(t, os.Link(filepath.Join(folder, "a.txt"), filepath.Join(folder, "b.txt")))
+
+	for name, opts := range map[string]config.CreateOptions{
+		"detect":  {},
+		"disable": {DisableHardlinks: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "test.tar"))
+			require.NoError(t, err)
+			defer f.Close()
+			archive := NewWithOptions(f, opts)
+			defer archive.Close()
+
+			for _, file := range []string{"a.txt", "b.txt"} {
+				require.NoError(t, archive.Add(config.File{
+					Source:      filepath.Join(folder, file),
+					Destination: "dir/" + file,
+				}))
+			}
+			require.NoError(t, archive.Close())
+			require.NoError(t, f.Close())
+
+			f, err = os.Open(f.Name())
+			require.NoError(t, err)
+			defer f.Close()
+			r := tar.NewReader(f)
+			next, err := r.Next()
+			require.NoError(t, err)
+			require.Equal(t, byte(tar.TypeReg), next.Typeflag)
+			require.Equal(t, int64(7), next.Size)
+			next, err = r.Next()
+			require.NoError(t, err)
+			if opts.DisableHardlinks {
+				require.Equal(t, byte(tar.TypeReg), next.Typeflag)
+				require.Equal(t, int64(7), next.Size)
+				return
+			}
+			require.Equal(t, byte(tar.TypeLink), next.Typeflag)
+			require.Equal(t, "dir/a.txt", next.Linkname)
+			require.Equal(t, int64(0), next.Size)
+
+			dest := t.TempDir()
+			require.NoError(t, ExtractTar(f.Name(), dest, config.ExtractOptions{StripTopDir: true}))
+			a, err := os.Stat(filepath.Join(dest, "a.txt"))
+			require.NoError(t, err)
+			b, err := os.Stat(filepath.Join(dest, "b.txt"))
+			require.NoError(t, err)
+			require.True(t, os.SameFile(a, b))
+		})
+	}
+}
@@ -24,8 +24,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/internal/osutil"
+	"github.com/kumose-go/archive/internal/tarutil"
 )
 
 // ExtractTar extracts a .tar archive to the destination directory.
@@ -37,10 +40,54 @@ func ExtractTar(src, dest string, opts config.ExtractOptions) error {
 	}
 	defer file.Close()
 
-	tr := tar.NewReader(file)
+	return Extract(file, dest, opts)
+}
 
+// Extract extracts the tar stream read from r to the destination directory.
+// It is shared by the compressed tar formats once the stream is decompressed.
+// StripTopDir trims the top directory of the first entry from the names
+// under it; the other entries keep their paths.
+func Extract(r io.Reader, dest string, opts config.ExtractOptions) error {
+	// the top directory is the one of the first entry.
 	var topDir string
 	first := true
+	return extract(r, dest, opts, func(name string) string {
+		if first {
+			topDir = strings.SplitN(name, "/", 2)[0]
+			first = false
+		}
+		return trimTopDir(name, topDir)
+	})
+}
+
+// ExtractStripEach extracts the tar stream read from r like Extract, except
+// that StripTopDir removes the first component of every name, skipping the
+// top-level entries, as the tar.xz and tar.zst formats do.
+func ExtractStripEach(r io.Reader, dest string, opts config.ExtractOptions) error {
+	return extract(r, dest, opts, func(name string) string {
+		_, rest, _ := strings.Cut(name, "/")
+		return rest
+	})
+}
+
+// extract extracts the tar stream read from r, with strip giving the name
+// entries and hardlink targets are extracted to when StripTopDir is set, or
+// an empty one when nothing is left of them.
+func extract(r io.Reader, dest string, opts config.ExtractOptions, strip func(name string) string) error {
+	tr := tar.NewReader(r)
+	if !opts.StripTopDir {
+		strip = nil
+	}
+
+	// directory times are restored last, as extracting their contents
+	// updates them.
+	type dirTime struct {
+		path  string
+		mtime time.Time
+	}
+	var dirs []dirTime
+	// later entries of an archive supersede the ones of the same name.
+	extracted := map[string]bool{}
 
 	for {
 		header, err := tr.Next()
@@ -51,59 +98,137 @@ func ExtractTar(src, dest string, opts config.ExtractOptions) error {
 			return err
 		}
 
-		name := header.Name
-
-		// Determine top-level dir for StripTopDir
-		if first && opts.StripTopDir {
-			topDir = strings.SplitN(name, string(os.PathSeparator), 2)[0]
-			first = false
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
 		}
 
-		if opts.StripTopDir {
-			name = strings.TrimPrefix(name, topDir+"/")
+		name := header.Name
+		if strip != nil {
+			if name = strip(name); name == "" {
+				continue
+			}
 		}
 
 		targetPath := filepath.Join(dest, name)
+		overwrite := opts.Overwrite || extracted[targetPath]
+		extracted[targetPath] = true
 
 		switch header.Typeflag {
 		case tar.TypeDir:
 			if err := os.MkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
 				return err
 			}
-		case tar.TypeReg, tar.TypeRegA:
-			if !opts.Overwrite {
-				if _, err := os.Lstat(targetPath); err == nil {
-					return fmt.Errorf("file exists: %s", targetPath)
-				}
-			}
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+			if err := prepare(targetPath, overwrite, "file"); err != nil {
 				return err
 			}
-			outFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
+			outFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(header.Mode))
 			if err != nil {
 				return err
 			}
-			if _, err := io.Copy(outFile, tr); err != nil {
+			if err := writeFile(outFile, tr, tarutil.IsSparse(header)); err != nil {
 				outFile.Close()
 				return err
 			}
 			outFile.Close()
 		case tar.TypeSymlink:
-			if !opts.Overwrite {
-				if _, err := os.Lstat(targetPath); err == nil {
-					return fmt.Errorf("symlink exists: %s", targetPath)
-				}
-			}
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			if err := prepare(targetPath, overwrite, "symlink"); err != nil {
 				return err
 			}
 			if err := os.Symlink(header.Linkname, targetPath); err != nil {
 				return err
 			}
+		case tar.TypeLink:
+			linkName := header.Linkname
+			if strip != nil {
+				linkName = strip(linkName)
+			}
+			if !filepath.IsLocal(filepath.FromSlash(linkName)) {
+				return fmt.Errorf("hardlink %s points outside of the extracted tree: %s", header.Name, header.Linkname)
+			}
+			if err := prepare(targetPath, overwrite, "file"); err != nil {
+				return err
+			}
+			if err := link(dest, linkName, name); err != nil {
+				return fmt.Errorf("hardlink %s: %w", header.Name, err)
+			}
+			// the link shares the metadata of the file it points to.
+			continue
 		default:
+			// skip other types
 			continue
 		}
+
+		if opts.PreserveOwner {
+			if err := osutil.Chown(targetPath, header.Uid, header.Gid, header.Uname, header.Gname); err != nil {
+				return err
+			}
+		}
+		if opts.PreserveMTime {
+			if header.Typeflag == tar.TypeDir {
+				dirs = append(dirs, dirTime{path: targetPath, mtime: header.ModTime})
+			} else if err := osutil.Chtimes(targetPath, header.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := osutil.Chtimes(dirs[i].path, dirs[i].mtime); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+// prepare makes room for an entry at path, creating its parent directories.
+// An existing entry is an error unless overwrite is set, in which case
+// anything but a directory is removed, so that the new entry is created
+// rather than written through a symlink or a hardlink found there.
+func prepare(path string, overwrite bool, kind string) error {
+	if info, err := os.Lstat(path); err == nil {
+		if !overwrite {
+			return fmt.Errorf("%s exists: %s", kind, path)
+		}
+		if !info.IsDir() {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return os.MkdirAll(filepath.Dir(path), 0755)
+}
+
+// link creates the hardlink newname to oldname, both relative to dest. It
+// fails when either resolves outside of dest, including through symlinks.
+func link(dest, oldname, newname string) error {
+	root, err := os.OpenRoot(dest)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return root.Link(filepath.FromSlash(oldname), filepath.FromSlash(newname))
+}
+
+// writeFile copies the entry content read from r to f, recreating the holes
+// of sparse entries instead of writing their zeros.
+func writeFile(f *os.File, r io.Reader, sparse bool) error {
+	if !sparse {
+		_, err := io.Copy(f, r)
+		return err
+	}
+	w := &sparseWriter{f: f}
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// trimTopDir removes the top directory from name when name is under it.
+func trimTopDir(name, topDir string) string {
+	if name == topDir {
+		return ""
+	}
+	return strings.TrimPrefix(name, topDir+"/")
+}
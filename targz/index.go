@@ -0,0 +1,213 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package targz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	pgzip "github.com/klauspost/pgzip"
+	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/gzip"
+	"github.com/kumose-go/archive/internal/seekable"
+)
+
+// The checkpoint index is stored in the extra field of empty gzip members
+// appended to the archive, which gzip readers decompress to nothing. The
+// last member, of fixed size, locates the index.
+const (
+	indexID      = "KX" // subfield of the members holding checkpoints
+	footerID     = "KF" // subfield of the last member locating them
+	footerSize   = 10 + 2 + 4 + 24 + 2 + 8
+	pointSize    = 16
+	maxMemberIdx = (0xffff - 4) / pointSize // checkpoints per index member
+)
+
+// ErrNotSeekable is returned when opening a tar.gz archive without
+// checkpoint index as seekable.
+var ErrNotSeekable = errors.New("tar.gz archive has no checkpoint index")
+
+// checkpointWriter compresses its input in gzip members of blockSize bytes
+// each, and appends their checkpoint index once closed.
+type checkpointWriter struct {
+	w         *countingWriter
+	opts      config.CreateOptions
+	blockSize int64
+	gw        *pgzip.Writer
+	written   int64 // in the current member
+	total     int64
+	points    []seekable.Checkpoint
+}
+
+func newCheckpointWriter(target io.Writer, opts config.CreateOptions) *checkpointWriter {
+	w := &countingWriter{w: target}
+	return &checkpointWriter{
+		w:         w,
+		opts:      opts,
+		blockSize: int64(opts.SeekableBlockSize),
+		gw:        gzip.NewWriter(w, opts),
+		points:    []seekable.Checkpoint{{}},
+	}
+}
+
+func (c *checkpointWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if c.written == c.blockSize {
+			if err := c.next(); err != nil {
+				return n - len(p), err
+			}
+		}
+		take := min(c.blockSize-c.written, int64(len(p)))
+		written, err := c.gw.Write(p[:take])
+		c.written += int64(written)
+		c.total += int64(written)
+		p = p[written:]
+		if err != nil {
+			return n - len(p), err
+		}
+	}
+	return n, nil
+}
+
+// next ends the current member and starts a new one.
+func (c *checkpointWriter) next() error {
+	if err := c.gw.Close(); err != nil {
+		return err
+	}
+	c.points = append(c.points, seekable.Checkpoint{Compressed: c.w.n, Decompressed: c.total})
+	// archive metadata is only kept in the first member.
+	opts := c.opts
+	opts.Metadata = config.Metadata{}
+	c.gw = gzip.NewWriter(c.w, opts)
+	c.written = 0
+	return nil
+}
+
+// Close ends the last member and appends the checkpoint index.
+func (c *checkpointWriter) Close() error {
+	if err := c.gw.Close(); err != nil {
+		return err
+	}
+	offset := c.w.n
+	for i := 0; i < len(c.points); i += maxMemberIdx {
+		var data []byte
+		for _, p := range c.points[i:min(i+maxMemberIdx, len(c.points))] {
+			data = binary.LittleEndian.AppendUint64(data, uint64(p.Compressed))
+			data = binary.LittleEndian.AppendUint64(data, uint64(p.Decompressed))
+		}
+		if _, err := c.w.Write(emptyMember(indexID, data)); err != nil {
+			return err
+		}
+	}
+	var footer []byte
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(offset))
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(len(c.points)))
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(c.total))
+	_, err := c.w.Write(emptyMember(footerID, footer))
+	return err
+}
+
+// emptyMember encodes a gzip member without content, whose extra field
+// holds data in the subfield id.
+func emptyMember(id string, data []byte) []byte {
+	b := []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 255}
+	b = binary.LittleEndian.AppendUint16(b, uint16(4+len(data)))
+	b = append(b, id...)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(data)))
+	b = append(b, data...)
+	// an empty final deflate block, then the checksum and size of nothing.
+	b = append(b, 3, 0)
+	return append(b, make([]byte, 8)...)
+}
+
+// readMember decodes the member written by emptyMember at the start of b,
+// returning the subfield data and the size of the member.
+func readMember(b []byte, id string) ([]byte, int, bool) {
+	if len(b) < 16 || !bytes.Equal(b[:4], []byte{0x1f, 0x8b, 8, 4}) {
+		return nil, 0, false
+	}
+	xlen := int(binary.LittleEndian.Uint16(b[10:]))
+	size := 12 + xlen + 10
+	if xlen < 4 || len(b) < size || string(b[12:14]) != id || int(binary.LittleEndian.Uint16(b[14:])) != xlen-4 {
+		return nil, 0, false
+	}
+	return b[16 : 12+xlen], size, true
+}
+
+// OpenSeekable returns the decompressed content of the tar.gz archive r of
+// the given size, written with a checkpoint index, decoding it from the
+// member holding the offset read. It returns ErrNotSeekable when r has no
+// checkpoint index.
+func OpenSeekable(r io.ReaderAt, size int64) (io.ReadSeekCloser, error) {
+	if size < footerSize {
+		return nil, ErrNotSeekable
+	}
+	b := make([]byte, footerSize)
+	if _, err := r.ReadAt(b, size-footerSize); err != nil {
+		return nil, err
+	}
+	footer, _, ok := readMember(b, footerID)
+	if !ok || len(footer) != 24 {
+		return nil, ErrNotSeekable
+	}
+	offset := int64(binary.LittleEndian.Uint64(footer))
+	count := binary.LittleEndian.Uint64(footer[8:])
+	total := int64(binary.LittleEndian.Uint64(footer[16:]))
+	if offset < 0 || offset > size-footerSize {
+		return nil, ErrNotSeekable
+	}
+	b = make([]byte, size-footerSize-offset)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, err
+	}
+	var points []seekable.Checkpoint
+	for len(b) > 0 {
+		data, n, ok := readMember(b, indexID)
+		if !ok {
+			return nil, ErrNotSeekable
+		}
+		for ; len(data) >= pointSize; data = data[pointSize:] {
+			points = append(points, seekable.Checkpoint{
+				Compressed:   int64(binary.LittleEndian.Uint64(data)),
+				Decompressed: int64(binary.LittleEndian.Uint64(data[8:])),
+			})
+		}
+		b = b[n:]
+	}
+	if uint64(len(points)) != count || len(points) == 0 {
+		return nil, ErrNotSeekable
+	}
+	return seekable.NewReader(r, offset, points, total, func(r io.Reader) (io.ReadCloser, error) {
+		return pgzip.NewReader(r)
+	}), nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
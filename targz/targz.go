@@ -18,53 +18,98 @@
 package targz
 
 import (
-	"compress/gzip"
+	"errors"
 	"io"
+	"io/fs"
 
+	pgzip "github.com/klauspost/pgzip"
 	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/gzip"
 	"github.com/kumose-go/archive/tar"
 )
 
 // Archive as tar.gz.
 type Archive struct {
-	gw *gzip.Writer
+	gw io.WriteCloser
 	tw *tar.Archive
 }
 
 // New tar.gz archive.
 func New(target io.Writer) Archive {
-	// the error will be nil since the compression level is valid
-	gw, _ := gzip.NewWriterLevel(target, gzip.BestCompression)
-	tw := tar.New(gw)
+	return NewWithOptions(target, config.CreateOptions{})
+}
+
+// NewWithOptions creates a tar.gz archive written according to opts.
+// With opts.SeekableBlockSize, it is followed by a checkpoint index.
+func NewWithOptions(target io.Writer, opts config.CreateOptions) Archive {
+	gw := newWriter(target, opts)
+	tw := tar.NewWithOptions(gw, opts)
 	return Archive{
 		gw: gw,
 		tw: &tw,
 	}
 }
 
+// Copy creates a new tar.gz with the contents of the given tar.gz.
 func Copy(source io.Reader, target io.Writer) (Archive, error) {
-	// the error will be nil since the compression level is valid
-	gw, _ := gzip.NewWriterLevel(target, gzip.BestCompression)
-	srcgz, err := gzip.NewReader(source)
+	return CopyWithOptions(source, target, config.CreateOptions{})
+}
+
+// CopyWithOptions creates a new tar.gz with the contents of the given tar.gz,
+// written according to opts. The duplicate policy applies to the entries of
+// the given tar.gz too, and its gzip header is kept unless opts sets one.
+func CopyWithOptions(source io.Reader, target io.Writer, opts config.CreateOptions) (Archive, error) {
+	srcgz, err := pgzip.NewReader(source)
 	if err != nil {
 		return Archive{}, err
 	}
-	tw, err := tar.Copy(srcgz, gw)
+	if opts.Metadata.Comment == "" && opts.Metadata.Extra == nil {
+		opts.Metadata.Comment = srcgz.Comment
+		opts.Metadata.Extra = srcgz.Extra
+	}
+	gw := newWriter(target, opts)
+	tw, err := tar.CopyWithOptions(srcgz, gw, opts)
 	return Archive{
 		gw: gw,
 		tw: &tw,
 	}, err
 }
 
+// newWriter returns the gzip writer of a tar.gz archive written according
+// to opts.
+func newWriter(target io.Writer, opts config.CreateOptions) io.WriteCloser {
+	if opts.SeekableBlockSize > 0 {
+		return newCheckpointWriter(target, opts)
+	}
+	return gzip.NewWriter(target, opts)
+}
+
 // Close all closeables.
 func (a Archive) Close() error {
-	if err := a.tw.Close(); err != nil {
-		return err
-	}
-	return a.gw.Close()
+	return errors.Join(a.tw.Close(), a.gw.Close())
 }
 
 // Add file to the archive.
 func (a Archive) Add(f config.File) error {
 	return a.tw.Add(f)
 }
+
+// AddFS adds the file f.Source of fsys to the archive.
+func (a Archive) AddFS(fsys fs.FS, f config.File) error {
+	return a.tw.AddFS(fsys, f)
+}
+
+// AddReader adds a regular file whose content is read from r.
+func (a Archive) AddReader(f config.File, size int64, r io.Reader) error {
+	return a.tw.AddReader(f, size, r)
+}
+
+// AddBytes adds a regular file with the given content to the archive.
+func (a Archive) AddBytes(f config.File, content []byte) error {
+	return a.tw.AddBytes(f, content)
+}
+
+// Checksums returns the digests of the regular files added, sorted by name.
+func (a Archive) Checksums() []config.Checksum {
+	return a.tw.Checksums()
+}
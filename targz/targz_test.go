@@ -19,7 +19,9 @@ package targz
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
@@ -183,3 +185,94 @@ func TestTarGzFileInfo(t *testing.T) {
 	}
 	require.Equal(t, 1, found)
 }
+
+func TestTarGzParallel(t *testing.T) {
+	content := bytes.Repeat([]byte("some log line that compresses well\n"), 64*1024)
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.tar.gz"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive := NewWithOptions(f, config.CreateOptions{
+		GzipBlockSize:   64 * 1024,
+		GzipConcurrency: 4,
+	})
+	defer archive.Close()
+
+	require.NoError(t, archive.AddBytes(config.File{Destination: "big.log"}, content))
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	// the output must stay readable by a standard gzip reader.
+	f, err = os.Open(f.Name())
+	require.NoError(t, err)
+	defer f.Close()
+	gzf, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	defer gzf.Close()
+	r := tar.NewReader(gzf)
+	next, err := r.Next()
+	require.NoError(t, err)
+	require.Equal(t, "big.log", next.Name)
+	bts, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(content, bts))
+
+	dest := t.TempDir()
+	require.NoError(t, ExtractTargz(f.Name(), dest, config.ExtractOptions{}))
+	bts, err = os.ReadFile(filepath.Join(dest, "big.log"))
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(content, bts))
+}
+
+func TestTarGzSeekable(t *testing.T) {
+	var buf bytes.Buffer
+	archive := NewWithOptions(&buf, config.CreateOptions{
+		SeekableBlockSize: 1024,
+		Metadata:          config.Metadata{Comment: "indexed"},
+	})
+	for i := range 20 {
+		content := bytes.Repeat([]byte(fmt.Sprintf("entry %d\n", i)), 300)
+		require.NoError(t, archive.AddBytes(config.File{Destination: fmt.Sprintf("file%d.txt", i)}, content))
+	}
+	require.NoError(t, archive.Close())
+
+	// standard gzip readers see the members as a single stream.
+	gzf, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, "indexed", gzf.Comment)
+	stream, err := io.ReadAll(gzf)
+	require.NoError(t, err)
+
+	r, err := OpenSeekable(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	defer r.Close()
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, stream, all)
+
+	for _, offset := range []int64{40000, 1023, 1024, 0, int64(len(stream)) - 10} {
+		_, err := r.Seek(offset, io.SeekStart)
+		require.NoError(t, err)
+		got := make([]byte, 10)
+		_, err = io.ReadFull(r, got)
+		require.NoError(t, err)
+		require.Equal(t, stream[offset:offset+10], got)
+	}
+
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "test.tar.gz")
+	require.NoError(t, os.WriteFile(src, buf.Bytes(), 0o644))
+	require.NoError(t, ExtractTargz(src, filepath.Join(tmp, "out"), config.ExtractOptions{}))
+	content, err := os.ReadFile(filepath.Join(tmp, "out", "file19.txt"))
+	require.NoError(t, err)
+	require.Equal(t, bytes.Repeat([]byte("entry 19\n"), 300), content)
+}
+
+func TestTarGzNotSeekable(t *testing.T) {
+	var buf bytes.Buffer
+	archive := New(&buf)
+	require.NoError(t, archive.AddBytes(config.File{Destination: "foo.txt"}, []byte("foo")))
+	require.NoError(t, archive.Close())
+
+	_, err := OpenSeekable(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.ErrorIs(t, err, ErrNotSeekable)
+}
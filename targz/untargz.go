@@ -18,15 +18,12 @@
 package targz
 
 import (
-	"archive/tar"
-	"compress/gzip"
-	"fmt"
 	"io"
 	"os"
-	"path/filepath"
-	"strings"
 
+	gzip "github.com/klauspost/pgzip"
 	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/tar"
 )
 
 // ExtractTargz extracts a .tar.gz archive to the destination directory.
@@ -45,72 +42,23 @@ func ExtractTargz(src, dest string, opts config.ExtractOptions) error {
 	}
 	defer gzr.Close()
 
-	tr := tar.NewReader(gzr)
-
-	var topDir string
-	first := true
-
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-
-		name := header.Name
-		// Determine top-level dir for StripTopDir
-		if first && opts.StripTopDir {
-			topDir = strings.SplitN(name, string(os.PathSeparator), 2)[0]
-			first = false
-		}
-
-		if opts.StripTopDir {
-			name = strings.TrimPrefix(name, topDir+"/")
-		}
-		targetPath := filepath.Join(dest, name)
+	return tar.Extract(gzr, dest, opts)
+}
 
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
-				return err
-			}
-		case tar.TypeReg, tar.TypeRegA:
-			if !opts.Overwrite {
-				if _, err := os.Lstat(targetPath); err == nil {
-					return fmt.Errorf("file exists: %s", targetPath)
-				}
-			}
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-				return err
-			}
-			outFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(outFile, tr); err != nil {
-				outFile.Close()
-				return err
-			}
-			outFile.Close()
-		case tar.TypeSymlink:
-			if !opts.Overwrite {
-				if _, err := os.Lstat(targetPath); err == nil {
-					return fmt.Errorf("symlink exists: %s", targetPath)
-				}
-			}
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-				return err
-			}
-			if err := os.Symlink(header.Linkname, targetPath); err != nil {
-				return err
-			}
-		default:
-			// skip other types
-			continue
-		}
+// ReadMetadata reads the comment and extra field of the gzip header of the
+// tar.gz stream r, along with the records of its PAX global header.
+func ReadMetadata(r io.Reader) (config.Metadata, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return config.Metadata{}, err
 	}
+	defer gzr.Close()
 
-	return nil
+	meta, err := tar.ReadMetadata(gzr)
+	if err != nil {
+		return config.Metadata{}, err
+	}
+	meta.Comment = gzr.Comment
+	meta.Extra = gzr.Extra
+	return meta, nil
 }
@@ -18,7 +18,9 @@
 package tarxz
 
 import (
+	"errors"
 	"io"
+	"io/fs"
 
 	"github.com/kumose-go/archive/config"
 	"github.com/kumose-go/archive/tar"
@@ -33,8 +35,13 @@ type Archive struct {
 
 // New tar.xz archive.
 func New(target io.Writer) Archive {
+	return NewWithOptions(target, config.CreateOptions{})
+}
+
+// NewWithOptions creates a tar.xz archive written according to opts.
+func NewWithOptions(target io.Writer, opts config.CreateOptions) Archive {
 	xzw, _ := xz.WriterConfig{DictCap: 16 * 1024 * 1024}.NewWriter(target)
-	tw := tar.New(xzw)
+	tw := tar.NewWithOptions(xzw, opts)
 	return Archive{
 		xzw: xzw,
 		tw:  &tw,
@@ -43,13 +50,30 @@ func New(target io.Writer) Archive {
 
 // Close all closeables.
 func (a Archive) Close() error {
-	if err := a.tw.Close(); err != nil {
-		return err
-	}
-	return a.xzw.Close()
+	return errors.Join(a.tw.Close(), a.xzw.Close())
 }
 
 // Add file to the archive.
 func (a Archive) Add(f config.File) error {
 	return a.tw.Add(f)
 }
+
+// AddFS adds the file f.Source of fsys to the archive.
+func (a Archive) AddFS(fsys fs.FS, f config.File) error {
+	return a.tw.AddFS(fsys, f)
+}
+
+// AddReader adds a regular file whose content is read from r.
+func (a Archive) AddReader(f config.File, size int64, r io.Reader) error {
+	return a.tw.AddReader(f, size, r)
+}
+
+// AddBytes adds a regular file with the given content to the archive.
+func (a Archive) AddBytes(f config.File, content []byte) error {
+	return a.tw.AddBytes(f, content)
+}
+
+// Checksums returns the digests of the regular files added, sorted by name.
+func (a Archive) Checksums() []config.Checksum {
+	return a.tw.Checksums()
+}
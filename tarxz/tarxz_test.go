@@ -182,3 +182,30 @@ func TestTarXzFileInfo(t *testing.T) {
 	}
 	require.Equal(t, 1, found)
 }
+
+func TestTarXzExtractStripTopDir(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "test.tar.xz")
+	f, err := os.Create(src)
+	require.NoError(t, err)
+	defer f.Close()
+	archive := New(f)
+	defer archive.Close()
+	require.NoError(t, archive.AddBytes(config.File{Destination: "pkg-1.0/bin/tool"}, []byte("tool")))
+	require.NoError(t, archive.AddBytes(config.File{Destination: "other-1.0/lib/a.so"}, []byte("lib")))
+	require.NoError(t, archive.AddBytes(config.File{Destination: "README"}, []byte("readme")))
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	// the first component of every entry is removed, top-level entries are
+	// skipped.
+	dest := t.TempDir()
+	require.NoError(t, ExtractTarXZ(src, dest, config.ExtractOptions{StripTopDir: true}))
+	bts, err := os.ReadFile(filepath.Join(dest, "bin", "tool"))
+	require.NoError(t, err)
+	require.Equal(t, "tool", string(bts))
+	bts, err = os.ReadFile(filepath.Join(dest, "lib", "a.so"))
+	require.NoError(t, err)
+	require.Equal(t, "lib", string(bts))
+	_, err = os.Stat(filepath.Join(dest, "README"))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,176 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package tarzst
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/kumose-go/archive/internal/seekable"
+)
+
+// Magic numbers of the zstd seekable format: the seek table is a skippable
+// frame ending with a footer.
+const (
+	skippableMagic = 0x184D2A5E
+	seekableMagic  = 0x8F92EAB1
+	footerSize     = 9
+	checksumFlag   = 1 << 7
+)
+
+// ErrNotSeekable is returned when opening a tar.zst archive without seek
+// table as seekable.
+var ErrNotSeekable = errors.New("tar.zst archive has no seek table")
+
+// frame is an entry of the seek table.
+type frame struct {
+	compressed, decompressed uint32
+}
+
+// seekableWriter compresses its input in independent zstd frames of
+// blockSize bytes, listed in a seek table once closed.
+type seekableWriter struct {
+	w         io.Writer
+	enc       *zstd.Encoder
+	blockSize int
+	buf       []byte
+	out       []byte
+	frames    []frame
+}
+
+func newSeekableWriter(w io.Writer, blockSize int) *seekableWriter {
+	// the error will be nil since the options are valid
+	enc, _ := zstd.NewWriter(nil)
+	return &seekableWriter{
+		w:         w,
+		enc:       enc,
+		blockSize: min(blockSize, 1<<30),
+	}
+}
+
+func (s *seekableWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := min(s.blockSize-len(s.buf), len(p))
+		s.buf = append(s.buf, p[:take]...)
+		p = p[take:]
+		if len(s.buf) == s.blockSize {
+			if err := s.flush(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush writes the buffered content as a frame.
+func (s *seekableWriter) flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	s.out = s.enc.EncodeAll(s.buf, s.out[:0])
+	if _, err := s.w.Write(s.out); err != nil {
+		return err
+	}
+	s.frames = append(s.frames, frame{compressed: uint32(len(s.out)), decompressed: uint32(len(s.buf))})
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// Close writes the last frame and the seek table.
+func (s *seekableWriter) Close() error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	table := make([]byte, 0, 8+8*len(s.frames)+footerSize)
+	table = binary.LittleEndian.AppendUint32(table, skippableMagic)
+	table = binary.LittleEndian.AppendUint32(table, uint32(8*len(s.frames)+footerSize))
+	for _, f := range s.frames {
+		table = binary.LittleEndian.AppendUint32(table, f.compressed)
+		table = binary.LittleEndian.AppendUint32(table, f.decompressed)
+	}
+	table = binary.LittleEndian.AppendUint32(table, uint32(len(s.frames)))
+	table = append(table, 0)
+	table = binary.LittleEndian.AppendUint32(table, seekableMagic)
+	_, err := s.w.Write(table)
+	return err
+}
+
+// OpenSeekable returns the decompressed content of the seekable tar.zst
+// archive r of the given size, decoding it from the frame holding the
+// offset read. It returns ErrNotSeekable when r has no seek table.
+func OpenSeekable(r io.ReaderAt, size int64) (io.ReadSeekCloser, error) {
+	points, total, err := readSeekTable(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return seekable.NewReader(r, size, points, total, func(r io.Reader) (io.ReadCloser, error) {
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}), nil
+}
+
+// readSeekTable reads the seek table at the end of r and returns the
+// checkpoint of each frame, along with the decompressed size.
+func readSeekTable(r io.ReaderAt, size int64) ([]seekable.Checkpoint, int64, error) {
+	if size < 8+footerSize {
+		return nil, 0, ErrNotSeekable
+	}
+	footer := make([]byte, footerSize)
+	if _, err := r.ReadAt(footer, size-footerSize); err != nil {
+		return nil, 0, err
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekableMagic {
+		return nil, 0, ErrNotSeekable
+	}
+	frames := int64(binary.LittleEndian.Uint32(footer))
+	entrySize := int64(8)
+	if footer[4]&checksumFlag != 0 {
+		entrySize += 4
+	}
+	tableSize := frames*entrySize + footerSize
+	if 8+tableSize > size {
+		return nil, 0, ErrNotSeekable
+	}
+	table := make([]byte, 8+tableSize-footerSize)
+	if _, err := r.ReadAt(table, size-8-tableSize); err != nil {
+		return nil, 0, err
+	}
+	if binary.LittleEndian.Uint32(table) != skippableMagic || int64(binary.LittleEndian.Uint32(table[4:])) != tableSize {
+		return nil, 0, ErrNotSeekable
+	}
+	points := make([]seekable.Checkpoint, 0, frames)
+	var point seekable.Checkpoint
+	for entry := table[8:]; len(entry) >= int(entrySize); entry = entry[entrySize:] {
+		points = append(points, point)
+		point.Compressed += int64(binary.LittleEndian.Uint32(entry))
+		point.Decompressed += int64(binary.LittleEndian.Uint32(entry[4:]))
+	}
+	if point.Compressed != size-8-tableSize {
+		return nil, 0, ErrNotSeekable
+	}
+	if len(points) == 0 {
+		points = append(points, point)
+	}
+	return points, point.Decompressed, nil
+}
@@ -18,7 +18,9 @@
 package tarzst
 
 import (
+	"errors"
 	"io"
+	"io/fs"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/kumose-go/archive/config"
@@ -27,29 +29,57 @@ import (
 
 // Archive as tar.zst.
 type Archive struct {
-	zstw *zstd.Encoder
-	tw   *tar.Archive
+	zw io.WriteCloser
+	tw *tar.Archive
 }
 
 // New tar.zst archive.
 func New(target io.Writer) Archive {
-	zstw, _ := zstd.NewWriter(target)
-	tw := tar.New(zstw)
+	return NewWithOptions(target, config.CreateOptions{})
+}
+
+// NewWithOptions creates a tar.zst archive written according to opts.
+// With opts.SeekableBlockSize, it follows the zstd seekable format.
+func NewWithOptions(target io.Writer, opts config.CreateOptions) Archive {
+	var zw io.WriteCloser
+	if opts.SeekableBlockSize > 0 {
+		zw = newSeekableWriter(target, opts.SeekableBlockSize)
+	} else {
+		zw, _ = zstd.NewWriter(target)
+	}
+	tw := tar.NewWithOptions(zw, opts)
 	return Archive{
-		zstw: zstw,
-		tw:   &tw,
+		zw: zw,
+		tw: &tw,
 	}
 }
 
 // Close all closeables.
 func (a Archive) Close() error {
-	if err := a.tw.Close(); err != nil {
-		return err
-	}
-	return a.zstw.Close()
+	return errors.Join(a.tw.Close(), a.zw.Close())
 }
 
 // Add file to the archive.
 func (a Archive) Add(f config.File) error {
 	return a.tw.Add(f)
 }
+
+// AddFS adds the file f.Source of fsys to the archive.
+func (a Archive) AddFS(fsys fs.FS, f config.File) error {
+	return a.tw.AddFS(fsys, f)
+}
+
+// AddReader adds a regular file whose content is read from r.
+func (a Archive) AddReader(f config.File, size int64, r io.Reader) error {
+	return a.tw.AddReader(f, size, r)
+}
+
+// AddBytes adds a regular file with the given content to the archive.
+func (a Archive) AddBytes(f config.File, content []byte) error {
+	return a.tw.AddBytes(f, content)
+}
+
+// Checksums returns the digests of the regular files added, sorted by name.
+func (a Archive) Checksums() []config.Checksum {
+	return a.tw.Checksums()
+}
@@ -19,6 +19,8 @@ package tarzst
 
 import (
 	"archive/tar"
+	"bytes"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
@@ -182,3 +184,100 @@ func TestTarZstFileInfo(t *testing.T) {
 	}
 	require.Equal(t, 1, found)
 }
+
+func TestTarZstSeekable(t *testing.T) {
+	var buf bytes.Buffer
+	archive := NewWithOptions(&buf, config.CreateOptions{SeekableBlockSize: 1024})
+	for i := range 20 {
+		content := bytes.Repeat([]byte(fmt.Sprintf("entry %d\n", i)), 300)
+		require.NoError(t, archive.AddBytes(config.File{Destination: fmt.Sprintf("file%d.txt", i)}, content))
+	}
+	require.NoError(t, archive.Close())
+
+	// regular zstd readers skip the seek table.
+	dec, err := zstd.NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	defer dec.Close()
+	stream, err := io.ReadAll(dec)
+	require.NoError(t, err)
+
+	r, err := OpenSeekable(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	defer r.Close()
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, stream, all)
+
+	for _, offset := range []int64{40000, 1023, 1024, 0, int64(len(stream)) - 10} {
+		_, err := r.Seek(offset, io.SeekStart)
+		require.NoError(t, err)
+		got := make([]byte, 10)
+		_, err = io.ReadFull(r, got)
+		require.NoError(t, err)
+		require.Equal(t, stream[offset:offset+10], got)
+	}
+
+	var found int
+	tr := tar.NewReader(r)
+	_, err = r.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if h.Name == "file13.txt" {
+			content, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			require.Equal(t, bytes.Repeat([]byte("entry 13\n"), 300), content)
+		}
+		found++
+	}
+	require.Equal(t, 20, found)
+
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "test.tar.zst")
+	require.NoError(t, os.WriteFile(src, buf.Bytes(), 0o644))
+	require.NoError(t, ExtractTarZST(src, filepath.Join(tmp, "out"), config.ExtractOptions{}))
+	content, err := os.ReadFile(filepath.Join(tmp, "out", "file19.txt"))
+	require.NoError(t, err)
+	require.Equal(t, bytes.Repeat([]byte("entry 19\n"), 300), content)
+}
+
+func TestTarZstNotSeekable(t *testing.T) {
+	var buf bytes.Buffer
+	archive := New(&buf)
+	require.NoError(t, archive.AddBytes(config.File{Destination: "foo.txt"}, []byte("foo")))
+	require.NoError(t, archive.Close())
+
+	_, err := OpenSeekable(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.ErrorIs(t, err, ErrNotSeekable)
+}
+
+func TestTarZstExtractStripTopDir(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "test.tar.zst")
+	f, err := os.Create(src)
+	require.NoError(t, err)
+	defer f.Close()
+	archive := New(f)
+	defer archive.Close()
+	require.NoError(t, archive.AddBytes(config.File{Destination: "pkg-1.0/bin/tool"}, []byte("tool")))
+	require.NoError(t, archive.AddBytes(config.File{Destination: "other-1.0/lib/a.so"}, []byte("lib")))
+	require.NoError(t, archive.AddBytes(config.File{Destination: "README"}, []byte("readme")))
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	// the first component of every entry is removed, top-level entries are
+	// skipped.
+	dest := t.TempDir()
+	require.NoError(t, ExtractTarZST(src, dest, config.ExtractOptions{StripTopDir: true}))
+	bts, err := os.ReadFile(filepath.Join(dest, "bin", "tool"))
+	require.NoError(t, err)
+	require.Equal(t, "tool", string(bts))
+	bts, err = os.ReadFile(filepath.Join(dest, "lib", "a.so"))
+	require.NoError(t, err)
+	require.Equal(t, "lib", string(bts))
+	_, err = os.Stat(filepath.Join(dest, "README"))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
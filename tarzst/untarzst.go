@@ -18,15 +18,12 @@
 package tarzst
 
 import (
-	"archive/tar"
-	"fmt"
 	"io"
 	"os"
-	"path/filepath"
-	"strings"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/tar"
 )
 
 // ExtractTarZST extracts a .tar.zst archive to dest directory.
@@ -43,66 +40,17 @@ func ExtractTarZST(src, dest string, opts config.ExtractOptions) error {
 	}
 	defer dec.Close()
 
-	tr := tar.NewReader(dec)
-
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-
-		target := filepath.Join(dest, header.Name)
-
-		// Strip top-level directory if requested
-		if opts.StripTopDir {
-			parts := strings.SplitN(header.Name, string(os.PathSeparator), 2)
-			if len(parts) == 2 {
-				target = filepath.Join(dest, parts[1])
-			} else {
-				// top-level file/dir, skip if nothing left
-				continue
-			}
-		}
+	return tar.ExtractStripEach(dec, dest, opts)
+}
 
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
-				return err
-			}
-		case tar.TypeReg, tar.TypeRegA:
-			if !opts.Overwrite {
-				if _, err := os.Stat(target); err == nil {
-					return fmt.Errorf("file %s exists", target)
-				}
-			} else {
-				_ = os.MkdirAll(filepath.Dir(target), 0755)
-			}
-			outFile, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(outFile, tr); err != nil {
-				outFile.Close()
-				return err
-			}
-			outFile.Close()
-		case tar.TypeSymlink:
-			if !opts.Overwrite {
-				if _, err := os.Lstat(target); err == nil {
-					return fmt.Errorf("symlink %s exists", target)
-				}
-			}
-			_ = os.MkdirAll(filepath.Dir(target), 0755)
-			if err := os.Symlink(header.Linkname, target); err != nil {
-				return err
-			}
-		default:
-			// skip other types for simplicity
-		}
+// ReadMetadata reads the records of the PAX global header of the tar.zst
+// stream r.
+func ReadMetadata(r io.Reader) (config.Metadata, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return config.Metadata{}, err
 	}
+	defer dec.Close()
 
-	return nil
+	return tar.ReadMetadata(dec)
 }
@@ -25,6 +25,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/require"
 	"github.com/ulikunitz/xz"
 )
@@ -39,6 +40,8 @@ func GetFileFromArchive(tb testing.TB, path, format, filename string) []byte {
 		return catTarFile(tb, openGzip(tb, f), filename)
 	case "tar.xz", "txz":
 		return catTarFile(tb, openXz(tb, f), filename)
+	case "tar.zst", "tzst":
+		return catTarFile(tb, openZst(tb, f), filename)
 	case "tar":
 		return catTarFile(tb, f, filename)
 	case "zip":
@@ -62,6 +65,8 @@ func LsArchive(tb testing.TB, path, format string) []string {
 		return doLsTar(openGzip(tb, f))
 	case "tar.xz", "txz":
 		return doLsTar(openXz(tb, f))
+	case "tar.zst", "tzst":
+		return doLsTar(openZst(tb, f))
 	case "tar":
 		return doLsTar(f)
 	case "zip":
@@ -88,6 +93,14 @@ func openXz(tb testing.TB, r io.Reader) *xz.Reader {
 	return xz
 }
 
+func openZst(tb testing.TB, r io.Reader) io.Reader {
+	tb.Helper()
+	zr, err := zstd.NewReader(r)
+	require.NoError(tb, err)
+	tb.Cleanup(zr.Close)
+	return zr
+}
+
 func catZipFile(tb testing.TB, f *os.File, path string) []byte {
 	tb.Helper()
 
@@ -0,0 +1,96 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package zip
+
+import (
+	"archive/zip"
+	"encoding/binary"
+)
+
+// unixExtraID is the Info-ZIP "new Unix" extra field, holding the numeric
+// user and group ids of an entry.
+const unixExtraID = 0x7875
+
+// unixExtra encodes the Info-ZIP Unix extra field for the given ids.
+func unixExtra(uid, gid int) []byte {
+	b := make([]byte, 0, 15)
+	b = binary.LittleEndian.AppendUint16(b, unixExtraID)
+	b = binary.LittleEndian.AppendUint16(b, 11)
+	b = append(b, 1, 4)
+	b = binary.LittleEndian.AppendUint32(b, uint32(uid))
+	b = append(b, 4)
+	b = binary.LittleEndian.AppendUint32(b, uint32(gid))
+	return b
+}
+
+// parseUnixExtra looks up the Info-ZIP Unix extra field in extra and returns
+// the numeric user and group ids it holds.
+func parseUnixExtra(extra []byte) (uid, gid int, ok bool) {
+	field := findExtra(extra, unixExtraID)
+	if len(field) < 1 || field[0] != 1 {
+		return 0, 0, false
+	}
+	field = field[1:]
+	id, field, ok := readExtraID(field)
+	if !ok {
+		return 0, 0, false
+	}
+	uid = id
+	gid, _, ok = readExtraID(field)
+	return uid, gid, ok
+}
+
+// findExtra returns the data of the first extra field with the given id.
+func findExtra(extra []byte, id uint16) []byte {
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			return nil
+		}
+		if tag == id {
+			return extra[:size]
+		}
+		extra = extra[size:]
+	}
+	return nil
+}
+
+// readExtraID reads a size prefixed little endian id.
+func readExtraID(b []byte) (int, []byte, bool) {
+	if len(b) < 1 {
+		return 0, nil, false
+	}
+	size := int(b[0])
+	b = b[1:]
+	if size > len(b) || size > 8 {
+		return 0, nil, false
+	}
+	var id uint64
+	for i := size - 1; i >= 0; i-- {
+		id = id<<8 | uint64(b[i])
+	}
+	return int(id), b[size:], true
+}
+
+// Owner returns the numeric user and group ids recorded in the Unix extra
+// field of the zip entry f, if any.
+func Owner(f *zip.File) (uid, gid int, ok bool) {
+	return parseUnixExtra(f.Extra)
+}
@@ -0,0 +1,97 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/kumose-go/archive/config"
+)
+
+// DefaultStoredExtensions lists the extensions of already compressed files,
+// stored without compression unless CreateOptions.StoredExtensions is set.
+var DefaultStoredExtensions = []string{
+	".7z", ".aac", ".apk", ".avif", ".br", ".bz2", ".deb", ".docx", ".epub",
+	".flac", ".gif", ".gz", ".heic", ".jar", ".jpeg", ".jpg", ".lz4", ".lzma",
+	".mkv", ".mov", ".mp3", ".mp4", ".odt", ".ogg", ".png", ".pptx", ".rar",
+	".rpm", ".tgz", ".txz", ".tzst", ".webm", ".webp", ".whl", ".woff2",
+	".xlsx", ".xz", ".zip", ".zst",
+}
+
+// sampleSize is the amount of content compressed to decide whether an entry
+// benefits from compression.
+const sampleSize = 64 * 1024
+
+// method returns the compression method of the entry f whose content is read
+// from r, along with a reader yielding that same content.
+func (a Archive) method(f config.File, r io.Reader) (uint16, io.Reader, error) {
+	switch f.Method {
+	case "store":
+		return zip.Store, r, nil
+	case "deflate":
+		return zip.Deflate, r, nil
+	case "":
+	default:
+		return 0, nil, fmt.Errorf("%s: invalid compression method: %s", f.Destination, f.Method)
+	}
+
+	extensions := a.opts.StoredExtensions
+	if extensions == nil {
+		extensions = DefaultStoredExtensions
+	}
+	ext := strings.ToLower(path.Ext(f.Destination))
+	if ext != "" && slices.ContainsFunc(extensions, func(e string) bool {
+		return strings.EqualFold(strings.TrimPrefix(e, "."), ext[1:])
+	}) {
+		return zip.Store, r, nil
+	}
+	if !a.opts.SampleCompression {
+		return zip.Deflate, r, nil
+	}
+
+	sample := make([]byte, sampleSize)
+	n, err := io.ReadFull(r, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, nil, fmt.Errorf("%s: %w", f.Destination, err)
+	}
+	sample = sample[:n]
+	r = io.MultiReader(bytes.NewReader(sample), r)
+	if !compressible(sample) {
+		return zip.Store, r, nil
+	}
+	return zip.Deflate, r, nil
+}
+
+// compressible reports whether compressing sample saves at least 5% of it.
+func compressible(sample []byte) bool {
+	if len(sample) == 0 {
+		return false
+	}
+	var out bytes.Buffer
+	w, _ := flate.NewWriter(&out, flate.BestSpeed)
+	_, _ = w.Write(sample)
+	_ = w.Close()
+	return out.Len() < len(sample)*95/100
+}
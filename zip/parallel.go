@@ -0,0 +1,125 @@
+// Copyright (C) Kumo inc. and its affiliates.
+// Author: Jeff.li lijippy@163.com
+// All rights reserved.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package zip
+
+import (
+	"archive/zip"
+	"sync"
+)
+
+// pool compresses entries on worker goroutines and appends them to the
+// archive, either in the order they were submitted or as soon as they are
+// compressed. Compressed entries are held in memory until written.
+type pool struct {
+	z       *zip.Writer
+	arrival bool
+	slots   chan struct{} // one per entry being compressed or waiting
+	wg      sync.WaitGroup
+
+	mu    sync.Mutex
+	seq   int           // sequence number of the next submitted entry
+	next  int           // sequence number of the next entry to write
+	ready map[int]entry // compressed entries waiting for their turn
+	err   error         // first error, reported by later calls
+}
+
+func newPool(z *zip.Writer, workers int, arrival bool) *pool {
+	return &pool{
+		z:       z,
+		arrival: arrival,
+		slots:   make(chan struct{}, workers),
+		ready:   map[int]entry{},
+	}
+}
+
+// entry is a compressed entry, with the function to call once it is
+// written to the archive.
+type entry struct {
+	f       *zip.File
+	written func()
+}
+
+// submit runs compress on a worker, blocking while all of them are busy,
+// and calls written, if not nil, once the entry is appended to the archive.
+// It returns the error of an entry submitted earlier, if any.
+func (p *pool) submit(compress func() (*zip.File, error), written func()) error {
+	p.mu.Lock()
+	err, seq := p.err, p.seq
+	p.seq++
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	p.slots <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		f, err := compress()
+		p.done(seq, entry{f: f, written: written}, err)
+	}()
+	return nil
+}
+
+// done writes the entry compressed under seq, along with the following ones
+// that were waiting for it, unless entries are written in arrival order.
+func (p *pool) done(seq int, e entry, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+	if p.arrival {
+		p.write(e)
+		return
+	}
+	p.ready[seq] = e
+	for {
+		e, ok := p.ready[p.next]
+		if !ok {
+			return
+		}
+		delete(p.ready, p.next)
+		p.next++
+		p.write(e)
+	}
+}
+
+// write appends the compressed entry e, if any, and frees its slot.
+func (p *pool) write(e entry) {
+	defer func() { <-p.slots }()
+	if e.f == nil || p.err != nil {
+		return
+	}
+	if err := p.z.Copy(e.f); err != nil {
+		p.err = err
+		return
+	}
+	if e.written != nil {
+		e.written()
+	}
+}
+
+// wait waits for the submitted entries to be written and returns the first
+// error met.
+func (p *pool) wait() error {
+	p.wg.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
@@ -24,8 +24,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/internal/osutil"
 )
 
 // ExtractZip extracts a .zip archive to dest directory.
@@ -36,12 +38,22 @@ func ExtractZip(src, dest string, opts config.ExtractOptions) error {
 	}
 	defer r.Close()
 
+	// directory times are restored last, as extracting their contents
+	// updates them.
+	type dirTime struct {
+		path  string
+		mtime time.Time
+	}
+	var dirs []dirTime
+	// later entries of an archive supersede the ones of the same name.
+	extracted := map[string]bool{}
+
 	for _, f := range r.File {
 		name := f.Name
 
 		// Strip top-level directory if requested
 		if opts.StripTopDir {
-			parts := strings.SplitN(name, string(os.PathSeparator), 2)
+			parts := strings.SplitN(name, "/", 2)
 			if len(parts) == 2 {
 				name = parts[1]
 			} else {
@@ -51,11 +63,19 @@ func ExtractZip(src, dest string, opts config.ExtractOptions) error {
 		}
 
 		target := filepath.Join(dest, name)
+		overwrite := opts.Overwrite || extracted[target]
+		extracted[target] = true
 
 		if f.FileInfo().IsDir() {
 			if err := os.MkdirAll(target, f.Mode()); err != nil {
 				return err
 			}
+			if err := restoreOwner(target, f, opts); err != nil {
+				return err
+			}
+			if opts.PreserveMTime {
+				dirs = append(dirs, dirTime{path: target, mtime: f.Modified})
+			}
 			continue
 		}
 
@@ -64,13 +84,20 @@ func ExtractZip(src, dest string, opts config.ExtractOptions) error {
 			return err
 		}
 
-		if !opts.Overwrite {
-			if _, err := os.Stat(target); err == nil {
+		if info, err := os.Lstat(target); err == nil {
+			if !overwrite {
 				return fmt.Errorf("file %s exists", target)
 			}
+			// the file is created anew rather than written through a
+			// symlink or a hardlink found there.
+			if !info.IsDir() {
+				if err := os.Remove(target); err != nil {
+					return err
+				}
+			}
 		}
 
-		outFile, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.Mode())
+		outFile, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, f.Mode())
 		if err != nil {
 			return err
 		}
@@ -89,7 +116,35 @@ func ExtractZip(src, dest string, opts config.ExtractOptions) error {
 
 		rc.Close()
 		outFile.Close()
+
+		if err := restoreOwner(target, f, opts); err != nil {
+			return err
+		}
+		if opts.PreserveMTime {
+			if err := osutil.Chtimes(target, f.Modified); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := osutil.Chtimes(dirs[i].path, dirs[i].mtime); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+// restoreOwner applies the ownership recorded in the Unix extra field of f
+// to target when requested.
+func restoreOwner(target string, f *zip.File, opts config.ExtractOptions) error {
+	if !opts.PreserveOwner {
+		return nil
+	}
+	uid, gid, ok := parseUnixExtra(f.Extra)
+	if !ok {
+		return nil
+	}
+	return osutil.Chown(target, uid, gid, "", "")
+}
@@ -19,35 +19,76 @@ package zip
 
 import (
 	"archive/zip"
+	"bytes"
 	"compress/flate"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/kumose-go/archive/config"
+	"github.com/kumose-go/archive/internal/checksum"
+	"github.com/kumose-go/archive/internal/names"
+	"github.com/kumose-go/archive/internal/osutil"
+	"github.com/kumose-go/archive/internal/sized"
 )
 
 // Archive zip struct.
 type Archive struct {
 	z     *zip.Writer
 	files map[string]bool
+	opts  config.CreateOptions
+	sums  *checksum.Recorder
+	mu    *sync.Mutex
+	pool  *pool
 }
 
 // New zip archive.
 func New(target io.Writer) Archive {
-	compressor := zip.NewWriter(target)
-	compressor.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
-		return flate.NewWriter(out, flate.BestCompression)
-	})
+	return NewWithOptions(target, config.CreateOptions{})
+}
+
+// NewWithOptions creates a zip archive written according to opts.
+// It is safe for concurrent use.
+func NewWithOptions(target io.Writer, opts config.CreateOptions) Archive {
+	compressor := newWriter(target)
+	var p *pool
+	if opts.ZipWorkers > 0 {
+		p = newPool(compressor, opts.ZipWorkers, opts.ZipArrivalOrder)
+	}
 	return Archive{
 		z:     compressor,
 		files: map[string]bool{},
+		opts:  opts,
+		sums:  checksum.NewRecorder(opts.Checksums),
+		mu:    &sync.Mutex{},
+		pool:  p,
 	}
 }
 
+// newWriter returns a zip writer deflating at best compression.
+func newWriter(target io.Writer) *zip.Writer {
+	w := zip.NewWriter(target)
+	w.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.BestCompression)
+	})
+	return w
+}
+
+// Copy creates a new zip with the contents of the given zip.
 func Copy(source *os.File, target io.Writer) (Archive, error) {
+	return CopyWithOptions(source, target, config.CreateOptions{})
+}
+
+// CopyWithOptions creates a new zip with the contents of the given zip,
+// written according to opts. The duplicate policy applies to the entries
+// of the given zip too, and its comment is kept unless opts sets one.
+func CopyWithOptions(source *os.File, target io.Writer, opts config.CreateOptions) (Archive, error) {
 	info, err := source.Stat()
 	if err != nil {
 		return Archive{}, err
@@ -56,15 +97,33 @@ func Copy(source *os.File, target io.Writer) (Archive, error) {
 	if err != nil {
 		return Archive{}, err
 	}
-	w := New(target)
+	if opts.Metadata.Comment == "" {
+		opts.Metadata.Comment = r.Comment
+	}
+	w := NewWithOptions(target, opts)
 	for _, zf := range r.File {
-		w.files[zf.Name] = true
+		// root entries, such as "./", are not files.
+		name := names.Normalize(zf.Name)
+		if name != "" {
+			ok, err := names.Claim(w.files, name, opts.Duplicates)
+			if err != nil {
+				return Archive{}, err
+			}
+			if !ok {
+				continue
+			}
+		}
 		hdr := zip.FileHeader{
 			Name:               zf.Name,
 			UncompressedSize64: zf.UncompressedSize64,
 			UncompressedSize:   zf.UncompressedSize,
 			CreatorVersion:     zf.CreatorVersion,
 			ExternalAttrs:      zf.ExternalAttrs,
+			Modified:           zf.Modified,
+			Comment:            zf.Comment,
+		}
+		if uid, gid, ok := parseUnixExtra(zf.Extra); ok {
+			hdr.Extra = unixExtra(uid, gid)
 		}
 		ww, err := w.z.CreateHeader(&hdr)
 		if err != nil {
@@ -73,71 +132,281 @@ func Copy(source *os.File, target io.Writer) (Archive, error) {
 		if zf.Mode().IsDir() {
 			continue
 		}
+		sum, err := w.sums.Hasher()
+		if err != nil {
+			return Archive{}, err
+		}
 		rr, err := zf.Open()
 		if err != nil {
 			return Archive{}, fmt.Errorf("opening %q from source: %w", zf.Name, err)
 		}
 		defer rr.Close()
-		if _, err = io.Copy(ww, rr); err != nil {
+		if _, err = io.Copy(ww, io.TeeReader(rr, sum)); err != nil {
 			return Archive{}, fmt.Errorf("copy from %q source to target: %w", zf.Name, err)
 		}
 		_ = rr.Close()
+		if zf.Mode().IsRegular() {
+			w.sums.Record(name, sum)
+		}
 	}
 	return w, nil
 }
 
-// Close all closeables.
+// Close all closeables, once the checksum manifest is written, if any.
 func (a Archive) Close() error {
-	return a.z.Close()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var errs []error
+	if a.pool != nil {
+		errs = append(errs, a.pool.wait())
+	}
+	if errors.Join(errs...) == nil && a.opts.ChecksumManifest != "" {
+		errs = append(errs, a.writeManifest())
+	}
+	if a.opts.Metadata.Comment != "" {
+		errs = append(errs, a.z.SetComment(a.opts.Metadata.Comment))
+	}
+	// the central directory is written even when an entry is not.
+	errs = append(errs, a.z.Close())
+	return errors.Join(errs...)
 }
 
-// Add a file to the zip archive.
-func (a Archive) Add(f config.File) error {
-	if _, ok := a.files[f.Destination]; ok {
-		return &fs.PathError{Err: fs.ErrExist, Path: f.Destination, Op: "add"}
+// writeManifest writes the entry listing the digests of the entries.
+func (a Archive) writeManifest() error {
+	name := names.Normalize(a.opts.ChecksumManifest)
+	if ok, err := names.Claim(a.files, name, a.opts.Duplicates); !ok {
+		return err
+	}
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
 	}
-	a.files[f.Destination] = true
-	info, err := os.Lstat(f.Source) // #nosec
+	header.SetMode(0o644)
+	w, err := a.z.CreateHeader(header)
 	if err != nil {
-		return err
+		return fmt.Errorf("%s: %w", name, err)
 	}
-	if info.IsDir() {
+	if _, err := w.Write(a.sums.Manifest()); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// Checksums returns the digests of the regular files added, sorted by name,
+// computed with the algorithms set in the options.
+func (a Archive) Checksums() []config.Checksum {
+	return a.sums.Checksums()
+}
+
+// ReadMetadata reads the archive comment of the zip archive r of the given
+// size, along with the comments of its entries, by entry name.
+func ReadMetadata(r io.ReaderAt, size int64) (config.Metadata, map[string]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return config.Metadata{}, nil, err
+	}
+	comments := map[string]string{}
+	for _, zf := range zr.File {
+		if zf.Comment != "" {
+			comments[zf.Name] = zf.Comment
+		}
+	}
+	return config.Metadata{Comment: zr.Comment}, comments, nil
+}
+
+// Add a file to the zip archive.
+func (a Archive) Add(f config.File) error {
+	return a.AddFS(osutil.FS{}, f)
+}
+
+// AddFS adds the file f.Source of fsys to the zip archive. Symlinks are
+// archived as such when fsys implements fs.ReadLinkFS, unless
+// f.FollowSymlinks is set.
+func (a Archive) AddFS(fsys fs.FS, f config.File) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	name := names.Normalize(f.Destination)
+	if ok, err := names.Claim(a.files, name, a.opts.Duplicates); !ok {
 		return err
 	}
+	info, link, err := osutil.Lstat(fsys, f.Source, f.FollowSymlinks, a.opts.RejectDanglingSymlinks)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.Source, err)
+	}
 	header, err := zip.FileInfoHeader(info)
 	if err != nil {
 		return err
 	}
-	header.Name = f.Destination
+	header.Name = name
 	header.Method = zip.Deflate
-	if !f.Info.ParsedMTime.IsZero() {
-		header.Modified = f.Info.ParsedMTime
+	header.Extra = ownerExtra(info, f.Info)
+	header.Comment = f.Comment
+	applyInfo(header, f.Info)
+	if info.IsDir() {
+		// directory entries are recognized by their trailing slash and
+		// carry no content.
+		header.Name += "/"
+		header.Method = zip.Store
+		header.SetMode(header.Mode() | fs.ModeDir)
+		return a.write(f, header, nil)
 	}
-	if f.Info.Mode != 0 {
-		header.SetMode(f.Info.Mode)
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return a.write(f, header, func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(filepath.ToSlash(link))), nil
+		})
 	}
-	w, err := a.z.CreateHeader(header)
+	return a.write(f, header, func() (io.ReadCloser, error) {
+		return fsys.Open(f.Source)
+	})
+}
+
+// AddReader adds a regular file whose content is read from r.
+// The entry is named after f.Destination and takes its metadata from f.Info;
+// f.Source is ignored. It is an error for r to yield fewer or more than size
+// bytes, unless size is negative.
+func (a Archive) AddReader(f config.File, size int64, r io.Reader) error {
+	r = sized.Reader(r, size)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	name := names.Normalize(f.Destination)
+	if ok, err := names.Claim(a.files, name, a.opts.Duplicates); !ok {
+		return err
+	}
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+		Extra:    ownerExtra(nil, f.Info),
+		Comment:  f.Comment,
+	}
+	header.SetMode(0o644)
+	applyInfo(header, f.Info)
+	if a.pool != nil {
+		// r may not be used once this returns, while the entry is only
+		// compressed later.
+		bts, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Destination, err)
+		}
+		r = bytes.NewReader(bts)
+	}
+	return a.write(f, header, func() (io.ReadCloser, error) {
+		return io.NopCloser(r), nil
+	})
+}
+
+// write writes the entry of header, with the content read from open, or
+// none when open is nil. With parallel compression, the entry is compressed
+// by the pool and written once its turn comes.
+func (a Archive) write(f config.File, header *zip.FileHeader, open func() (io.ReadCloser, error)) error {
+	sum, err := a.sums.Hasher()
 	if err != nil {
 		return err
 	}
-	if info.IsDir() {
+	// the digests are recorded once the entry is in the archive, replacing
+	// the ones of an entry of the same name written before.
+	var record func()
+	if open != nil && header.Mode().IsRegular() {
+		record = func() { a.sums.Record(header.Name, sum) }
+	}
+	if a.pool == nil {
+		if err := a.writeEntry(a.z, f, header, open, sum); err != nil {
+			return err
+		}
+		if record != nil {
+			record()
+		}
 		return nil
 	}
-	if info.Mode()&os.ModeSymlink != 0 {
-		link, err := os.Readlink(f.Source) // #nosec
+	return a.pool.submit(func() (*zip.File, error) {
+		var buf bytes.Buffer
+		z := newWriter(&buf)
+		if err := a.writeEntry(z, f, header, open, sum); err != nil {
+			return nil, err
+		}
+		if err := z.Close(); err != nil {
+			return nil, err
+		}
+		r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
 		if err != nil {
-			return fmt.Errorf("%s: %w", f.Source, err)
+			return nil, err
 		}
-		_, err = io.WriteString(w, filepath.ToSlash(link))
+		return r.File[0], nil
+	}, record)
+}
+
+// writeEntry writes the entry of header to z, compressed with the method
+// picked for f, hashing its content with sum.
+func (a Archive) writeEntry(z *zip.Writer, f config.File, header *zip.FileHeader, open func() (io.ReadCloser, error), sum *checksum.Hasher) error {
+	if open == nil {
+		_, err := z.CreateHeader(header)
 		return err
 	}
-	file, err := os.Open(f.Source) // #nosec
+	rc, err := open()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	_, err = io.Copy(w, file)
-	return err
+	defer rc.Close()
+	var r io.Reader = rc
+	header.Method, r, err = a.method(f, r)
+	if err != nil {
+		return err
+	}
+	w, err := z.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, io.TeeReader(r, sum)); err != nil {
+		return fmt.Errorf("%s: %w", f.Destination, err)
+	}
+	return nil
+}
+
+// AddBytes adds a regular file with the given content to the archive.
+func (a Archive) AddBytes(f config.File, content []byte) error {
+	return a.AddReader(f, int64(len(content)), bytes.NewReader(content))
+}
+
+// applyInfo overrides the header metadata with the one set in info.
+func applyInfo(header *zip.FileHeader, info config.FileInfo) {
+	if !info.ParsedMTime.IsZero() {
+		header.Modified = info.ParsedMTime
+	}
+	if info.Mode != 0 {
+		header.SetMode(info.Mode)
+	}
+}
+
+// ownerExtra returns the Unix extra field recording the entry owner. The owner
+// set in info takes precedence over the one of the source file, if any.
+func ownerExtra(src fs.FileInfo, info config.FileInfo) []byte {
+	var uid, gid int
+	var ok bool
+	if src != nil {
+		uid, gid, ok = osutil.Owner(src)
+	}
+	// zip has no room for names, a named owner is stored as id 0.
+	if info.Owner != "" {
+		uid = 0
+		ok = true
+	}
+	if info.Group != "" {
+		gid = 0
+		ok = true
+	}
+	if info.UID != nil {
+		uid = *info.UID
+		ok = true
+	}
+	if info.GID != nil {
+		gid = *info.GID
+		ok = true
+	}
+	if !ok {
+		return nil
+	}
+	return unixExtra(uid, gid)
 }
 
 // TODO: test fileinfo stuff
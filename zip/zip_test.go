@@ -20,10 +20,15 @@ package zip
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -95,7 +100,7 @@ func TestZipFile(t *testing.T) {
 
 	info, err := f.Stat()
 	require.NoError(t, err)
-	require.Lessf(t, info.Size(), int64(1000), "archived file should be smaller than %d", info.Size())
+	require.Lessf(t, info.Size(), int64(1500), "archived file should be smaller than %d", info.Size())
 
 	r, err := zip.NewReader(f, info.Size())
 	require.NoError(t, err)
@@ -125,8 +130,10 @@ func TestZipFile(t *testing.T) {
 	}
 	require.Equal(t, []string{
 		"foo.txt",
+		"sub1/",
 		"sub1/bar.txt",
 		"sub1/executable",
+		"sub1/sub2/",
 		"sub1/sub2/subfoo.txt",
 		"regular.txt",
 		"link.txt",
@@ -183,4 +190,462 @@ func TestTarInvalidLink(t *testing.T) {
 	}))
 }
 
+func TestZipAddReader(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.zip"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive := New(f)
+	defer archive.Close()
+
+	require.NoError(t, archive.AddReader(config.File{
+		Destination: "checksums.txt",
+		Info: config.FileInfo{
+			Mode:        0o600,
+			ParsedMTime: now,
+		},
+	}, 5, bytes.NewReader([]byte("hello"))))
+	require.NoError(t, archive.AddBytes(config.File{
+		Destination: "version",
+	}, []byte("v1.0.0")))
+	require.ErrorIs(t, archive.AddBytes(config.File{
+		Destination: "version",
+	}, []byte("v2.0.0")), fs.ErrExist)
+
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	require.Equal(t, "hello", string(testlib.GetFileFromArchive(t, f.Name(), "zip", "checksums.txt")))
+	require.Equal(t, "v1.0.0", string(testlib.GetFileFromArchive(t, f.Name(), "zip", "version")))
+
+	r, err := zip.OpenReader(f.Name())
+	require.NoError(t, err)
+	defer r.Close()
+	require.Len(t, r.File, 2)
+	require.Equal(t, now.Unix(), r.File[0].Modified.Unix())
+	require.Equal(t, fs.FileMode(0o600), r.File[0].Mode())
+	require.Equal(t, fs.FileMode(0o644), r.File[1].Mode())
+}
+
+func TestZipDirectory(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	folder := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(folder, "logs"), 0o700))
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.zip"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive := New(f)
+	defer archive.Close()
+
+	require.NoError(t, archive.Add(config.File{
+		Source:      filepath.Join(folder, "logs"),
+		Destination: "logs",
+	}))
+	require.NoError(t, archive.Add(config.File{
+		Source:      filepath.Join(folder, "logs"),
+		Destination: "data/",
+		Info: config.FileInfo{
+			Mode:        0o750,
+			ParsedMTime: now,
+		},
+	}))
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	r, err := zip.OpenReader(f.Name())
+	require.NoError(t, err)
+	defer r.Close()
+	require.Len(t, r.File, 2)
+	require.Equal(t, "logs/", r.File[0].Name)
+	require.True(t, r.File[0].Mode().IsDir())
+	require.Equal(t, "data/", r.File[1].Name)
+	require.True(t, r.File[1].Mode().IsDir())
+	require.Equal(t, now.Unix(), r.File[1].Modified.Unix())
+	if !testlib.IsWindows() {
+		require.Equal(t, fs.FileMode(0o700), r.File[0].Mode().Perm())
+		require.Equal(t, fs.FileMode(0o750), r.File[1].Mode().Perm())
+	}
+
+	dest := t.TempDir()
+	require.NoError(t, ExtractZip(f.Name(), dest, config.ExtractOptions{}))
+	for _, name := range []string{"logs", "data"} {
+		info, err := os.Stat(filepath.Join(dest, name))
+		require.NoError(t, err)
+		require.True(t, info.IsDir())
+	}
+}
+
+func TestExtractZipReplaceSymlink(t *testing.T) {
+	testlib.SkipIfWindows(t, "symlinks are not supported on windows")
+	outside := filepath.Join(t.TempDir(), "outside")
+	require.NoError(t, os.WriteFile(outside, []byte("outside"), 0o644))
+	dest := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(dest, "x")))
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.zip"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive := New(f)
+	defer archive.Close()
+	require.NoError(t, archive.AddBytes(config.File{Destination: "x"}, []byte("replaced")))
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	require.ErrorContains(t, ExtractZip(f.Name(), dest, config.ExtractOptions{}), "exists")
+	require.NoError(t, ExtractZip(f.Name(), dest, config.ExtractOptions{Overwrite: true}))
+	bts, err := os.ReadFile(outside)
+	require.NoError(t, err)
+	require.Equal(t, "outside", string(bts))
+	bts, err = os.ReadFile(filepath.Join(dest, "x"))
+	require.NoError(t, err)
+	require.Equal(t, "replaced", string(bts))
+}
+
+func TestZipUnixMetadata(t *testing.T) {
+	testlib.SkipIfWindows(t, "ownership is not supported on windows")
+	now := time.Now().Add(-time.Hour).Truncate(time.Second)
+	uid, gid := os.Getuid(), os.Getgid()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.zip"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive := New(f)
+	defer archive.Close()
+
+	require.NoError(t, archive.Add(config.File{
+		Source:      "../testdata/sub1",
+		Destination: "sub1",
+		Info: config.FileInfo{
+			ParsedMTime: now,
+		},
+	}))
+	require.NoError(t, archive.Add(config.File{
+		Source:      "../testdata/foo.txt",
+		Destination: "sub1/foo.txt",
+		Info: config.FileInfo{
+			UID:         &uid,
+			GID:         &gid,
+			ParsedMTime: now,
+		},
+	}))
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	r, err := zip.OpenReader(f.Name())
+	require.NoError(t, err)
+	defer r.Close()
+	require.Len(t, r.File, 2)
+	for _, zf := range r.File {
+		zuid, zgid, ok := parseUnixExtra(zf.Extra)
+		require.True(t, ok, zf.Name)
+		require.Equal(t, uid, zuid, zf.Name)
+		require.Equal(t, gid, zgid, zf.Name)
+		require.Equal(t, now.Unix(), zf.Modified.Unix())
+	}
+
+	dest := t.TempDir()
+	require.NoError(t, ExtractZip(f.Name(), dest, config.ExtractOptions{
+		PreserveOwner: true,
+		PreserveMTime: true,
+	}))
+	for _, name := range []string{"sub1", "sub1/foo.txt"} {
+		info, err := os.Stat(filepath.Join(dest, name))
+		require.NoError(t, err)
+		require.Equal(t, now.Unix(), info.ModTime().Unix(), name)
+	}
+}
+
+func TestZipFileInfoIDs(t *testing.T) {
+	uid, gid := 1000, 1001
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.zip"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive := New(f)
+	defer archive.Close()
+
+	require.NoError(t, archive.AddBytes(config.File{
+		Destination: "numeric.txt",
+		Info: config.FileInfo{
+			Owner: "carlos",
+			UID:   &uid,
+			GID:   &gid,
+		},
+	}, []byte("foo")))
+	require.NoError(t, archive.AddBytes(config.File{
+		Destination: "named.txt",
+		Info: config.FileInfo{
+			Owner: "nobody",
+			Group: "nobody",
+		},
+	}, []byte("foo")))
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	r, err := zip.OpenReader(f.Name())
+	require.NoError(t, err)
+	defer r.Close()
+	require.Len(t, r.File, 2)
+	zuid, zgid, ok := parseUnixExtra(r.File[0].Extra)
+	require.True(t, ok)
+	require.Equal(t, uid, zuid)
+	require.Equal(t, gid, zgid)
+	// names are not looked up on the current system.
+	zuid, zgid, ok = parseUnixExtra(r.File[1].Extra)
+	require.True(t, ok)
+	require.Equal(t, 0, zuid)
+	require.Equal(t, 0, zgid)
+}
+
+func TestZipMethod(t *testing.T) {
+	random := make([]byte, 128*1024)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+	text := bytes.Repeat([]byte("compress me please\n"), 1024)
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.zip"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive := NewWithOptions(f, config.CreateOptions{SampleCompression: true})
+	defer archive.Close()
+
+	require.NoError(t, archive.AddBytes(config.File{Destination: "image.PNG"}, text))
+	require.NoError(t, archive.AddBytes(config.File{Destination: "text.txt"}, text))
+	require.NoError(t, archive.AddBytes(config.File{Destination: "random.bin"}, random))
+	require.NoError(t, archive.AddBytes(config.File{Destination: "forced.bin", Method: "deflate"}, random))
+	require.NoError(t, archive.AddBytes(config.File{Destination: "stored.txt", Method: "store"}, text))
+	require.NoError(t, archive.Add(config.File{Source: "../testdata/foo.txt", Destination: "foo.txt"}))
+	require.EqualError(t, archive.AddBytes(config.File{
+		Destination: "bad.txt",
+		Method:      "bzip2",
+	}, text), "bad.txt: invalid compression method: bzip2")
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	r, err := zip.OpenReader(f.Name())
+	require.NoError(t, err)
+	defer r.Close()
+	methods := map[string]uint16{}
+	for _, zf := range r.File {
+		methods[zf.Name] = zf.Method
+	}
+	require.Equal(t, map[string]uint16{
+		"image.PNG":  zip.Store,
+		"text.txt":   zip.Deflate,
+		"random.bin": zip.Store,
+		"forced.bin": zip.Deflate,
+		"stored.txt": zip.Store,
+		"foo.txt":    zip.Store,
+	}, methods)
+	require.Equal(t, random, testlib.GetFileFromArchive(t, f.Name(), "zip", "random.bin"))
+	require.Equal(t, text, testlib.GetFileFromArchive(t, f.Name(), "zip", "text.txt"))
+}
+
+func TestZipStoredExtensions(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.zip"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive := NewWithOptions(f, config.CreateOptions{StoredExtensions: []string{"txt"}})
+	defer archive.Close()
+
+	require.NoError(t, archive.Add(config.File{Source: "../testdata/foo.txt", Destination: "foo.txt"}))
+	require.NoError(t, archive.AddBytes(config.File{Destination: "image.png"}, []byte("png")))
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	r, err := zip.OpenReader(f.Name())
+	require.NoError(t, err)
+	defer r.Close()
+	require.Len(t, r.File, 2)
+	require.Equal(t, zip.Store, r.File[0].Method)
+	require.Equal(t, zip.Deflate, r.File[1].Method)
+}
+
 // TODO: add copying test
+
+func TestZipComments(t *testing.T) {
+	tmp := t.TempDir()
+	f, err := os.Create(filepath.Join(tmp, "test.zip"))
+	require.NoError(t, err)
+	defer f.Close()
+	archive := NewWithOptions(f, config.CreateOptions{
+		Metadata: config.Metadata{Comment: "version 1.2.3"},
+	})
+	require.NoError(t, archive.Add(config.File{
+		Source:      "../testdata/regular.txt",
+		Destination: "regular.txt",
+		Comment:     "from disk",
+	}))
+	require.NoError(t, archive.AddBytes(config.File{
+		Destination: "generated.txt",
+		Comment:     "generated",
+	}, []byte("generated")))
+	require.NoError(t, archive.AddBytes(config.File{Destination: "plain.txt"}, nil))
+	require.NoError(t, archive.Close())
+	require.NoError(t, f.Close())
+
+	expected := map[string]string{"regular.txt": "from disk", "generated.txt": "generated"}
+	check := func(name string) {
+		t.Helper()
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		defer f.Close()
+		info, err := f.Stat()
+		require.NoError(t, err)
+		meta, comments, err := ReadMetadata(f, info.Size())
+		require.NoError(t, err)
+		require.Equal(t, "version 1.2.3", meta.Comment)
+		require.Equal(t, expected, comments)
+	}
+	check(f.Name())
+
+	src, err := os.Open(f.Name())
+	require.NoError(t, err)
+	defer src.Close()
+	copied, err := os.Create(filepath.Join(tmp, "copy.zip"))
+	require.NoError(t, err)
+	defer copied.Close()
+	archive, err = Copy(src, copied)
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+	require.NoError(t, copied.Close())
+	check(copied.Name())
+}
+
+func TestZipDuplicates(t *testing.T) {
+	tmp := t.TempDir()
+	src, err := os.Create(filepath.Join(tmp, "src.zip"))
+	require.NoError(t, err)
+	defer src.Close()
+	archive := New(src)
+	require.NoError(t, archive.AddBytes(config.File{Destination: "dir//a.txt"}, []byte("first")))
+	require.ErrorIs(t, archive.AddBytes(config.File{Destination: "/dir/a.txt"}, nil), fs.ErrExist)
+	require.NoError(t, archive.Add(config.File{Source: "../testdata/sub1", Destination: "./sub1/"}))
+	require.NoError(t, archive.Close())
+
+	for policy, expected := range map[string]string{
+		"error":   "",
+		"keep":    "first",
+		"replace": "second",
+	} {
+		t.Run(policy, func(t *testing.T) {
+			target, err := os.Create(filepath.Join(t.TempDir(), "target.zip"))
+			require.NoError(t, err)
+			defer target.Close()
+			archive, err := CopyWithOptions(src, target, config.CreateOptions{Duplicates: policy})
+			require.NoError(t, err)
+			err = archive.AddBytes(config.File{Destination: "dir/a.txt"}, []byte("second"))
+			if expected == "" {
+				require.ErrorIs(t, err, fs.ErrExist)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, archive.Close())
+			require.NoError(t, target.Close())
+
+			dest := t.TempDir()
+			require.NoError(t, ExtractZip(target.Name(), dest, config.ExtractOptions{}))
+			content, err := os.ReadFile(filepath.Join(dest, "dir", "a.txt"))
+			require.NoError(t, err)
+			require.Equal(t, expected, string(content))
+
+			names := []string{"dir/a.txt", "sub1/"}
+			if policy == "replace" {
+				names = append(names, "dir/a.txt")
+			}
+			require.Equal(t, names, testlib.LsArchive(t, target.Name(), "zip"))
+		})
+	}
+}
+
+func TestZipParallel(t *testing.T) {
+	for name, opts := range map[string]config.CreateOptions{
+		"serial":        {},
+		"deterministic": {ZipWorkers: 4},
+		"arrival":       {ZipWorkers: 4, ZipArrivalOrder: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "test.zip"))
+			require.NoError(t, err)
+			defer f.Close()
+			archive := NewWithOptions(f, opts)
+
+			var expected []string
+			var wg sync.WaitGroup
+			for i := range 20 {
+				name := "file" + strconv.Itoa(i) + ".txt"
+				expected = append(expected, name)
+				content := bytes.Repeat([]byte(name), 1000*(20-i))
+				if opts.ZipWorkers > 0 && !opts.ZipArrivalOrder {
+					require.NoError(t, archive.AddBytes(config.File{Destination: name}, content))
+					continue
+				}
+				wg.Go(func() {
+					require.NoError(t, archive.AddBytes(config.File{Destination: name}, content))
+				})
+			}
+			wg.Wait()
+			require.NoError(t, archive.Add(config.File{Source: "../testdata/sub1", Destination: "sub1"}))
+			require.NoError(t, archive.Add(config.File{Source: "../testdata/link.txt", Destination: "link.txt"}))
+			require.NoError(t, archive.Close())
+			require.NoError(t, f.Close())
+
+			expected = append(expected, "sub1/", "link.txt")
+			actual := testlib.LsArchive(t, f.Name(), "zip")
+			if opts.ZipWorkers > 0 && !opts.ZipArrivalOrder {
+				require.Equal(t, expected, actual)
+			} else {
+				require.ElementsMatch(t, expected, actual)
+			}
+			require.Equal(t, bytes.Repeat([]byte("file3.txt"), 17000), testlib.GetFileFromArchive(t, f.Name(), "zip", "file3.txt"))
+
+			dest := t.TempDir()
+			require.NoError(t, ExtractZip(f.Name(), dest, config.ExtractOptions{}))
+			info, err := os.Stat(filepath.Join(dest, "sub1"))
+			require.NoError(t, err)
+			require.True(t, info.IsDir())
+		})
+	}
+}
+
+func TestZipParallelChecksums(t *testing.T) {
+	large := make([]byte, 4<<20)
+	_, err := rand.Read(large)
+	require.NoError(t, err)
+
+	for _, arrival := range []bool{false, true} {
+		var buf bytes.Buffer
+		archive := NewWithOptions(&buf, config.CreateOptions{
+			ZipWorkers:      2,
+			ZipArrivalOrder: arrival,
+			Duplicates:      "replace",
+			Checksums:       []string{"sha256"},
+		})
+		// the small entry is likely compressed first, whatever the order
+		// entries are written in.
+		require.NoError(t, archive.AddBytes(config.File{Destination: "a.txt"}, large))
+		require.NoError(t, archive.AddBytes(config.File{Destination: "a.txt"}, []byte("small")))
+		require.NoError(t, archive.Close())
+
+		// the digests are the ones of the entry written last.
+		r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err)
+		require.Len(t, r.File, 2)
+		rc, err := r.File[1].Open()
+		require.NoError(t, err)
+		last, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		sum := sha256.Sum256(last)
+		require.Equal(t, []config.Checksum{{
+			Name:    "a.txt",
+			Digests: map[string]string{"sha256": hex.EncodeToString(sum[:])},
+		}}, archive.Checksums(), "arrival: %v", arrival)
+	}
+}
+
+func TestZipParallelError(t *testing.T) {
+	archive := NewWithOptions(io.Discard, config.CreateOptions{ZipWorkers: 2})
+	require.NoError(t, archive.AddBytes(config.File{Destination: "a.txt", Method: "nope"}, nil))
+	require.ErrorContains(t, archive.Close(), "invalid compression method: nope")
+}